@@ -0,0 +1,212 @@
+package aws
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/stxkxs/ok-cli/logger"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	archiveTimestamp = "20060102T150405Z"
+	exportPoll       = 5 * time.Second
+)
+
+// Archive keeps the events of a log group before it is deleted, either as
+// gzipped ndjson under Dir or exported to the s3 Bucket under Prefix. Both
+// are keyed by account, region, log group, and time. A log group is only
+// deleted once its archive completed.
+type Archive struct {
+	Dir    string `mapstructure:"dir"`
+	Bucket string `mapstructure:"bucket"`
+	Prefix string `mapstructure:"prefix"`
+}
+
+// archived is one line of a local archive.
+type archived struct {
+	LogStream     string `json:"logStream"`
+	Timestamp     int64  `json:"timestamp"`
+	IngestionTime int64  `json:"ingestionTime"`
+	EventId       string `json:"eventId"`
+	Message       string `json:"message"`
+}
+
+// exports serializes export tasks per region, since cloudwatch logs runs one
+// export task per account and region at a time.
+var exports sync.Map
+
+func (a Archive) enabled() bool {
+	return a.Dir != "" || a.Bucket != ""
+}
+
+func (a Archive) Validate() error {
+	if a.Dir != "" && a.Bucket != "" {
+		return fmt.Errorf("cloudwatch archive dir and bucket are mutually exclusive")
+	}
+	return nil
+}
+
+// archive archives every event of a log group and returns where to.
+func (a Archive) archive(ctx context.Context, cwl *cloudwatchlogs.Client, account, region, logGroupName string) (string, error) {
+	key := path.Join(account, region, strings.TrimPrefix(logGroupName, "/"), time.Now().UTC().Format(archiveTimestamp))
+
+	if a.Bucket != "" {
+		return a.export(ctx, cwl, region, logGroupName, path.Join(a.Prefix, key))
+	}
+
+	dir, err := expandHome(a.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	return a.download(ctx, cwl, logGroupName, filepath.Join(dir, filepath.FromSlash(key)+".ndjson.gz"))
+}
+
+// download writes every event of a log group to a gzipped ndjson file. The
+// file only appears under its name once every event was written.
+func (a Archive) download(ctx context.Context, cwl *cloudwatchlogs.Client, logGroupName, file string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(file), ".archive-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+
+	events := 0
+	input := &cloudwatchlogs.FilterLogEventsInput{LogGroupName: &logGroupName}
+	for {
+		resp, err := cwl.FilterLogEvents(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("logGroup", logGroupName).Msg("error reading log events")
+			return "", err
+		}
+
+		for _, e := range resp.Events {
+			err := enc.Encode(archived{
+				LogStream:     aws.ToString(e.LogStreamName),
+				Timestamp:     aws.ToInt64(e.Timestamp),
+				IngestionTime: aws.ToInt64(e.IngestionTime),
+				EventId:       aws.ToString(e.EventId),
+				Message:       aws.ToString(e.Message),
+			})
+			if err != nil {
+				return "", err
+			}
+		}
+		events += len(resp.Events)
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	if err := gz.Close(); err != nil {
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(f.Name(), file); err != nil {
+		return "", err
+	}
+
+	logger.Logger.Info().
+		Str("logGroup", logGroupName).
+		Str("archive", file).
+		Int("events", events).
+		Msg("archived log group")
+
+	return file, nil
+}
+
+// export exports every event of a log group to s3 and waits for the export
+// task to complete.
+func (a Archive) export(ctx context.Context, cwl *cloudwatchlogs.Client, region, logGroupName, prefix string) (string, error) {
+	mu, _ := exports.LoadOrStore(region, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	task, err := cwl.CreateExportTask(ctx, &cloudwatchlogs.CreateExportTaskInput{
+		LogGroupName:      &logGroupName,
+		Destination:       &a.Bucket,
+		DestinationPrefix: &prefix,
+		From:              aws.Int64(0),
+		To:                aws.Int64(time.Now().UnixMilli()),
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("logGroup", logGroupName).Str("bucket", a.Bucket).Msg("error creating log export task")
+		return "", err
+	}
+
+	destination := fmt.Sprintf("s3://%s/%s", a.Bucket, prefix)
+
+	logger.Logger.Info().
+		Str("logGroup", logGroupName).
+		Str("task", aws.ToString(task.TaskId)).
+		Str("archive", destination).
+		Msg("exporting log group")
+
+	for {
+		resp, err := cwl.DescribeExportTasks(ctx, &cloudwatchlogs.DescribeExportTasksInput{TaskId: task.TaskId})
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("task", aws.ToString(task.TaskId)).Msg("error describing log export task")
+			return "", err
+		}
+
+		if len(resp.ExportTasks) == 0 || resp.ExportTasks[0].Status == nil {
+			return "", fmt.Errorf("log export task %s not found", aws.ToString(task.TaskId))
+		}
+
+		status := resp.ExportTasks[0].Status
+		switch status.Code {
+		case types.ExportTaskStatusCodeCompleted:
+			logger.Logger.Info().
+				Str("logGroup", logGroupName).
+				Str("archive", destination).
+				Msg("archived log group")
+			return destination, nil
+		case types.ExportTaskStatusCodeCancelled, types.ExportTaskStatusCodeFailed:
+			return "", fmt.Errorf("log export task %s %s: %s", aws.ToString(task.TaskId), status.Code, aws.ToString(status.Message))
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(exportPoll):
+		}
+	}
+}
+
+func expandHome(dir string) (string, error) {
+	rest, ok := strings.CutPrefix(dir, "~")
+	if !ok {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, rest), nil
+}
@@ -29,7 +29,7 @@ type BillingCostManagementClient struct {
 }
 
 func NewBillingCostManagementClient() *BillingCostManagementClient {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	cfg, err := config.LoadDefaultConfig(context.Background(), WithRateLimit())
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("error loading default foundation configurations")
 		return nil
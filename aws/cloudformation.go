@@ -2,64 +2,308 @@ package aws
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"golang.org/x/time/rate"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
 	"github.com/stxkxs/ok-cli/logger"
 )
 
+// CloudFormation deletes the root stacks matching Prefix, optionally only
+// those in one of the given Status, e.g. ROLLBACK_COMPLETE or CREATE_FAILED.
+// Nested stacks are never targeted, cloudformation deletes them with their
+// root.
 type CloudFormation struct {
-	Region string   `mapstructure:"region"`
-	Retry  int      `mapstructure:"retry"`
-	Prefix []string `mapstructure:"prefix"`
+	Region         string                  `mapstructure:"region"`
+	Regions        []string                `mapstructure:"regions"`
+	Retry          int                     `mapstructure:"retry"`
+	Prefix         []string                `mapstructure:"prefix"`
+	Status         []string                `mapstructure:"status"`
+	Retention      Retention               `mapstructure:"retention"`
+	Tags           string                  `mapstructure:"tags"`
+	Protect        Protect                 `mapstructure:"protect"`
+	Grace          Grace                   `mapstructure:"grace"`
+	Parallelism    int                     `mapstructure:"parallelism"`
+	Timeout        time.Duration           `mapstructure:"timeout"`
+	OnDeleteFailed string                  `mapstructure:"onDeleteFailed"`
+	RetainTypes    []string                `mapstructure:"retainTypes"`
+	Snapshot       Snapshot                `mapstructure:"snapshot"`
+	Credentials    aws.CredentialsProvider `mapstructure:"-" json:"-"`
 }
 
-var cloudFormationRateLimit = rate.NewLimiter(rate.Limit(10), 10)
+const defaultStackTimeout = time.Hour
+
+const (
+	DeleteFailedSkip   = "skip"
+	DeleteFailedRetain = "retain"
+	DeleteFailedAbort  = "abort"
+)
+
+// StackFailure describes the resources that kept cloudformation from
+// deleting a stack.
+type StackFailure struct {
+	Stack     string
+	Resources []ResourceFailure
+}
+
+type ResourceFailure struct {
+	LogicalId string
+	Type      string
+	Reason    string
+}
+
+func (f *StackFailure) Error() string {
+	reasons := make([]string, 0, len(f.Resources))
+	for _, r := range f.Resources {
+		reasons = append(reasons, fmt.Sprintf("%s (%s): %s", r.LogicalId, r.Type, r.Reason))
+	}
+
+	return fmt.Sprintf("stack %s is DELETE_FAILED: %s", f.Stack, strings.Join(reasons, "; "))
+}
+
+func (f *StackFailure) retainable(retainTypes []string) []string {
+	var retain []string
+	for _, r := range f.Resources {
+		if slices.Contains(retainTypes, r.Type) {
+			retain = append(retain, r.LogicalId)
+		}
+	}
+	return retain
+}
+
+type cloudFormationReaper struct {
+	c       CloudFormation
+	account *callerAccount
+}
 
 func DestroyStacks(c CloudFormation) error {
-	ctx := context.Background()
+	_, err := Reap(context.Background(), c.reaper())
+	return err
+}
 
-	err, api := NewClient(c, ctx)
-	if err != nil {
+func ListStacks(c CloudFormation) ([]string, error) {
+	return Candidates(context.Background(), c.reaper())
+}
+
+func DeleteStacks(c CloudFormation, stackNames []string) error {
+	return c.reaper().Delete(context.Background(), stackNames)
+}
+
+func (c *CloudFormation) Scope() (string, []string) {
+	return c.Region, c.Regions
+}
+
+func (c *CloudFormation) Defaults(tags string, protect Protect, grace Grace) {
+	defaults(&c.Tags, &c.Protect, tags, protect)
+	c.Grace = c.Grace.or(grace)
+}
+
+func (c *CloudFormation) Validate() error {
+	for _, s := range c.Status {
+		if !slices.Contains(types.StackStatus("").Values(), types.StackStatus(strings.ToUpper(s))) {
+			return fmt.Errorf("invalid cloudformation stack status %q", s)
+		}
+	}
+
+	switch strings.ToLower(c.OnDeleteFailed) {
+	case "", DeleteFailedSkip, DeleteFailedAbort:
+		if len(c.RetainTypes) > 0 {
+			return fmt.Errorf("cloudformation retainTypes requires onDeleteFailed %s", DeleteFailedRetain)
+		}
+	case DeleteFailedRetain:
+	default:
+		return fmt.Errorf("invalid cloudformation onDeleteFailed %q: expected %s, %s, or %s", c.OnDeleteFailed, DeleteFailedSkip, DeleteFailedRetain, DeleteFailedAbort)
+	}
+
+	if err := c.Grace.Validate(); err != nil {
 		return err
 	}
 
-	err = cloudFormationRateLimit.Wait(ctx)
+	return validate(c.Tags, &c.Protect)
+}
+
+func (c *CloudFormation) Reaper(region string, credentials aws.CredentialsProvider) Reaper {
+	cf := *c
+	cf.Region = region
+	cf.Credentials = credentials
+	return cf.reaper()
+}
+
+func (c CloudFormation) reaper() cloudFormationReaper {
+	return cloudFormationReaper{c: c, account: newCallerAccount(c.Credentials)}
+}
+
+func (r cloudFormationReaper) List(ctx context.Context) ([]string, error) {
+	err, api := NewClient(r.c, ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var stackNames []string
-	err = getAllStackNames(ctx, api, &stackNames)
+	err = getAllStackNames(ctx, api, r.c, &stackNames)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var matched []string
+	for _, stackName := range stackNames {
+		if !matchesPrefix(stackName, r.c.Prefix) {
+			continue
+		}
+
+		if toolkit(stackName) {
+			logger.Logger.Info().Str("resource", stackName).Str("rule", "cdk toolkit").Msg("skipping protected resource")
+			skipped(ctx, stackName, "cdk toolkit")
+			continue
+		}
+
+		matched = append(matched, stackName)
+	}
+
+	return matched, nil
+}
+
+func (r cloudFormationReaper) Filter(ctx context.Context, stackNames []string) ([]string, error) {
+	cfg, err := r.c.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stackNames, err = selectTagged(ctx, cfg, r.c.Tags, tagTypeStack, stackNames, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	stackNames, err = r.c.Protect.filter(ctx, cfg, r.account, "cloudformation", tagTypeStack, stackNames, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	api := cloudformation.NewFromConfig(cfg)
+	return r.c.Grace.filter(ctx, cfg, r.account, tagTypeStack, stackNames, identity, func(_, stackName string) (string, error) {
+		stacks, err := api.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName})
+		if err != nil {
+			return "", err
+		}
+		if len(stacks.Stacks) == 0 {
+			return "", fmt.Errorf("stack not found: %s", stackName)
+		}
+		return aws.ToString(stacks.Stacks[0].StackId), nil
+	})
+}
+
+func (r cloudFormationReaper) Delete(ctx context.Context, stackNames []string) error {
 	if len(stackNames) == 0 {
-		logger.Logger.Warn().Str("region", c.Region).Msg("no stacks found in region")
+		logger.Logger.Warn().Str("region", r.c.Region).Msg("no stacks found in region")
 		return nil
 	}
 
+	timeout := r.c.Timeout
+	if timeout <= 0 {
+		timeout = defaultStackTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err, api := NewClient(r.c, ctx)
+	if err != nil {
+		return err
+	}
+
+	var account string
+	if !r.c.Snapshot.Disabled {
+		if account, err = r.account.get(); err != nil {
+			return err
+		}
+	}
+
+	g, err := newStackGraph(ctx, api, stackNames)
+	if err != nil {
+		return err
+	}
+
+	return g.delete(ctx, api, r.c, account)
+}
+
+// Reconcile waits for the stacks still deleting and reports which stacks are
+// gone. Stacks in any other state are left to delete again.
+func (r cloudFormationReaper) Reconcile(ctx context.Context, stackNames []string) ([]string, []string, error) {
+	err, api := NewClient(r.c, ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	timeout := r.c.Timeout
+	if timeout <= 0 {
+		timeout = defaultStackTimeout
+	}
+
+	var gone, remaining []string
 	for _, stackName := range stackNames {
-		if !toolkit(stackName) && matchesPrefix(stackName, c.Prefix) {
-			err := deleteStackAndWait(ctx, api, stackName)
-			if err != nil {
-				return err
-			}
+		status, err := getStackStatus(ctx, api, stackName)
+		if stackGone(err) || status == string(types.StackStatusDeleteComplete) {
+			gone = append(gone, stackName)
+			continue
 		}
+
+		if err != nil || status != string(types.StackStatusDeleteInProgress) {
+			remaining = append(remaining, stackName)
+			continue
+		}
+
+		logger.Logger.Info().Str("stack", stackName).Msg("waiting for stack deletion started by an earlier run")
+
+		waiter := cloudformation.NewStackDeleteCompleteWaiter(api)
+		if err := waiter.Wait(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName}, timeout); err != nil {
+			logger.Logger.Warn().Err(err).Str("stack", stackName).Msg("stack deletion started by an earlier run did not complete")
+			remaining = append(remaining, stackName)
+			continue
+		}
+
+		logger.Logger.Info().Str("stack", stackName).Msg("deleted stack")
+		gone = append(gone, stackName)
 	}
 
-	return nil
+	return gone, remaining, nil
+}
+
+// Gone reports the stacks that no longer exist or finished deleting.
+func (r cloudFormationReaper) Gone(ctx context.Context, stackNames []string) ([]string, error) {
+	err, api := NewClient(r.c, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var gone []string
+	for _, stackName := range stackNames {
+		status, err := getStackStatus(ctx, api, stackName)
+		if stackGone(err) || status == string(types.StackStatusDeleteComplete) {
+			gone = append(gone, stackName)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return gone, nil
+}
+
+func (r cloudFormationReaper) Describe(stackName string) string {
+	return fmt.Sprintf("cloudformation stack %s", stackName)
 }
 
 func NewClient(c CloudFormation, ctx context.Context) (error, *cloudformation.Client) {
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(c.Region), config.WithRetryMaxAttempts(c.Retry))
+	cfg, err := c.config(ctx)
 	if err != nil {
-		logger.Logger.Error().Err(err).Msg("error loading default aws configurations")
 		return err, nil
 	}
 
@@ -67,32 +311,134 @@ func NewClient(c CloudFormation, ctx context.Context) (error, *cloudformation.Cl
 	return err, api
 }
 
-func deleteStackAndWait(ctx context.Context, api *cloudformation.Client, stackName string) error {
+func (c CloudFormation) config(ctx context.Context) (aws.Config, error) {
+	return loadConfig(ctx, c.Region, c.Retry, c.Credentials)
+}
+
+func deleteStackAndWait(ctx context.Context, api *cloudformation.Client, stackName string, retain []string) error {
 	_, err := api.DeleteStack(ctx, &cloudformation.DeleteStackInput{
-		StackName: &stackName,
+		StackName:       &stackName,
+		RetainResources: retain,
 	})
 	if err != nil {
 		logger.Logger.Error().Err(err).Str("stack", stackName).Msg("error deleting stack")
 		return err
 	}
-	logger.Logger.Info().Str("stack", stackName).Msg("initiated deletion of stack")
+	logger.Logger.Info().Str("stack", stackName).Strs("retain", retain).Msg("initiated deletion of stack")
 
-	for {
-		status, err := getStackStatus(ctx, api, stackName)
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultStackTimeout)
+	}
+
+	waiter := cloudformation.NewStackDeleteCompleteWaiter(api)
+	err = waiter.Wait(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName}, time.Until(deadline))
+	if err != nil {
+		status, _ := getStackStatus(ctx, api, stackName)
+		if status != string(types.StackStatusDeleteFailed) {
+			logger.Logger.Error().Err(err).Str("stack", stackName).Str("status", status).Msg("error waiting for stack deletion")
+			return err
+		}
+
+		failure, err := stackFailure(ctx, api, stackName)
 		if err != nil {
 			return err
 		}
 
-		if status == "DELETE_COMPLETE" {
-			logger.Logger.Info().Str("stack", stackName).Msg("deleted stack")
-			return nil
-		} else if status == "DELETE_FAILED" || status == "DELETE_IN_PROGRESS" {
-			logger.Logger.Info().Str("stack", stackName).Str("status", status).Msg("waiting for stack deletion")
-			time.Sleep(20 * time.Second) // Wait for 20 seconds before checking again
-		} else {
-			return fmt.Errorf("unexpected stack status: %s", status)
+		for _, r := range failure.Resources {
+			logger.Logger.Error().
+				Str("stack", stackName).
+				Str("resource", r.LogicalId).
+				Str("type", r.Type).
+				Str("reason", r.Reason).
+				Msg("stack resource failed to delete")
 		}
+
+		return failure
 	}
+
+	logger.Logger.Info().Str("stack", stackName).Msg("deleted stack")
+	return nil
+}
+
+// deleteStack snapshots a stack into account, deletes it, and applies the
+// configured policy when cloudformation fails to remove some of its
+// resources. With the retain policy the deletion is retried once, retaining
+// every failed resource whose type is listed in RetainTypes.
+func deleteStack(ctx context.Context, api *cloudformation.Client, c CloudFormation, account, stackName string) error {
+	if !c.Snapshot.Disabled {
+		if _, err := c.Snapshot.save(ctx, api, c, account, stackName); err != nil {
+			logger.Logger.Error().Err(err).Str("stack", stackName).Msg("error saving stack snapshot. keeping it.")
+			return fmt.Errorf("snapshot: %w", err)
+		}
+	}
+
+	err := deleteStackAndWait(ctx, api, stackName, nil)
+
+	var failure *StackFailure
+	if !errors.As(err, &failure) || !strings.EqualFold(c.OnDeleteFailed, DeleteFailedRetain) {
+		return err
+	}
+
+	retain := failure.retainable(c.RetainTypes)
+	if len(retain) == 0 {
+		return err
+	}
+
+	logger.Logger.Warn().
+		Str("stack", stackName).
+		Strs("retain", retain).
+		Msg("retrying stack deletion retaining failed resources")
+
+	return deleteStackAndWait(ctx, api, stackName, retain)
+}
+
+// stackFailure collects the resources that failed during the most recent
+// deletion of a stack from its events, newest first.
+func stackFailure(ctx context.Context, api *cloudformation.Client, stackName string) (*StackFailure, error) {
+	failure := &StackFailure{Stack: stackName}
+	seen := make(map[string]bool)
+
+	input := &cloudformation.DescribeStackEventsInput{StackName: &stackName}
+	for {
+		resp, err := api.DescribeStackEvents(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("stack", stackName).Msg("error describing stack events")
+			return nil, err
+		}
+
+		for _, e := range resp.StackEvents {
+			logical := aws.ToString(e.LogicalResourceId)
+
+			if logical == stackName && e.ResourceStatus == types.ResourceStatusDeleteInProgress {
+				return failure, nil
+			}
+
+			if logical == stackName || e.ResourceStatus != types.ResourceStatusDeleteFailed || seen[logical] {
+				continue
+			}
+			seen[logical] = true
+
+			failure.Resources = append(failure.Resources, ResourceFailure{
+				LogicalId: logical,
+				Type:      aws.ToString(e.ResourceType),
+				Reason:    aws.ToString(e.ResourceStatusReason),
+			})
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return failure, nil
+}
+
+// stackGone reports whether err says the stack does not exist anymore.
+func stackGone(err error) bool {
+	var apiError smithy.APIError
+	return errors.As(err, &apiError) && strings.Contains(apiError.ErrorMessage(), "does not exist")
 }
 
 func getStackStatus(ctx context.Context, api *cloudformation.Client, stackName string) (string, error) {
@@ -110,7 +456,16 @@ func getStackStatus(ctx context.Context, api *cloudformation.Client, stackName s
 	return string(resp.Stacks[0].StackStatus), nil
 }
 
-func getAllStackNames(ctx context.Context, api *cloudformation.Client, stackNames *[]string) error {
+// getAllStackNames lists the root stacks in one of the statuses of c, or in
+// any status when none are configured.
+func getAllStackNames(ctx context.Context, api *cloudformation.Client, c CloudFormation, stackNames *[]string) error {
+	r := c.Retention
+
+	older, err := r.olderThan()
+	if err != nil {
+		return err
+	}
+
 	input := &cloudformation.DescribeStacksInput{}
 
 	for {
@@ -121,9 +476,33 @@ func getAllStackNames(ctx context.Context, api *cloudformation.Client, stackName
 		}
 
 		for _, stack := range re.Stacks {
-			if stack.StackName != nil {
-				*stackNames = append(*stackNames, *stack.StackName)
+			if stack.StackName == nil {
+				continue
+			}
+
+			if stack.ParentId != nil || stack.RootId != nil {
+				logger.Logger.Debug().Str("stack", *stack.StackName).Str("root", nameFromArn(aws.ToString(stack.RootId))).Msg("skipping nested stack")
+				continue
+			}
+
+			if len(c.Status) > 0 && !slices.ContainsFunc(c.Status, func(s string) bool { return strings.EqualFold(s, string(stack.StackStatus)) }) {
+				logger.Logger.Debug().Str("stack", *stack.StackName).Str("status", string(stack.StackStatus)).Msg("skipping stack with unselected status")
+				continue
 			}
+
+			if aws.ToBool(stack.EnableTerminationProtection) {
+				logger.Logger.Info().Str("resource", *stack.StackName).Str("rule", "termination protection").Msg("skipping protected resource")
+				skipped(ctx, *stack.StackName, "termination protection")
+				continue
+			}
+
+			if !older.IsZero() && lastUpdated(stack).After(older) {
+				logger.Logger.Debug().Str("stack", *stack.StackName).Str("olderThan", r.OlderThan).Msg("retaining stack updated after cutoff")
+				skipped(ctx, *stack.StackName, "retention olderThan "+r.OlderThan)
+				continue
+			}
+
+			*stackNames = append(*stackNames, *stack.StackName)
 		}
 
 		if re.NextToken == nil {
@@ -135,6 +514,18 @@ func getAllStackNames(ctx context.Context, api *cloudformation.Client, stackName
 	return nil
 }
 
+func lastUpdated(stack types.Stack) time.Time {
+	if stack.LastUpdatedTime != nil {
+		return *stack.LastUpdatedTime
+	}
+
+	if stack.CreationTime != nil {
+		return *stack.CreationTime
+	}
+
+	return time.Time{}
+}
+
 func matchesPrefix(stackName string, prefixes []string) bool {
 	if len(prefixes) == 0 {
 		return true
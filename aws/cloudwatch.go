@@ -2,89 +2,521 @@ package aws
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 	"github.com/stxkxs/ok-cli/logger"
-	"golang.org/x/time/rate"
+	"slices"
+	"strings"
+	"time"
 )
 
+// CloudWatch deletes the matching log groups, after archiving their events
+// when Archive is set, or with Mode enforce keeps them and sets their
+// retention policy to RetentionDays instead, skipping groups that already
+// expire their events at least as soon.
 type CloudWatch struct {
-	Region    string `mapstructure:"region"`
-	BatchSize int    `mapstructure:"batchSize"`
-	Retry     int    `mapstructure:"retry"`
+	Region        string    `mapstructure:"region"`
+	Regions       []string  `mapstructure:"regions"`
+	BatchSize     int       `mapstructure:"batchSize"`
+	Parallelism   int       `mapstructure:"parallelism"`
+	Retry         int       `mapstructure:"retry"`
+	Mode          string    `mapstructure:"mode"`
+	RetentionDays int32     `mapstructure:"retentionDays"`
+	Prefix        []string  `mapstructure:"prefix"`
+	Pattern       string    `mapstructure:"pattern"`
+	Class         string    `mapstructure:"class"`
+	CreatedBefore string    `mapstructure:"createdBefore"`
+	Retention     Retention `mapstructure:"retention"`
+	Archive       Archive   `mapstructure:"archive"`
+	Tags          string    `mapstructure:"tags"`
+	Protect       Protect   `mapstructure:"protect"`
+	Grace         Grace     `mapstructure:"grace"`
+	Selector      `mapstructure:",squash"`
+	Credentials   aws.CredentialsProvider `mapstructure:"-" json:"-"`
 }
 
-var cloudWatchRateLimit = rate.NewLimiter(rate.Limit(10), 10)
+const (
+	CloudWatchDelete  = "delete"
+	CloudWatchEnforce = "enforce"
+)
+
+// retentionDays are the only retention periods cloudwatch logs accepts.
+var retentionDays = []int32{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+// cloudWatchReaper remembers the stored bytes and retention, in days with 0
+// for never expire, of the log groups it listed.
+type cloudWatchReaper struct {
+	c       CloudWatch
+	listed  *listed[listedLogGroup]
+	account *callerAccount
+}
+
+// listedLogGroup is the stored bytes and retention of a log group when it
+// was listed.
+type listedLogGroup struct {
+	bytes     int64
+	retention int32
+}
 
 func DestroyLogGroups(c CloudWatch) error {
-	ctx := context.Background()
+	_, err := Reap(context.Background(), c.reaper())
+	return err
+}
 
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(c.Region), config.WithRetryMaxAttempts(c.Retry))
-	if err != nil {
-		logger.Logger.Error().Err(err).Msg("error loading default aws configurations")
+func ListLogGroups(c CloudWatch) ([]string, error) {
+	return Candidates(context.Background(), c.reaper())
+}
+
+func DeleteLogGroups(c CloudWatch, logGroupNames []string) error {
+	return c.reaper().Delete(context.Background(), logGroupNames)
+}
+
+func (c *CloudWatch) Scope() (string, []string) {
+	return c.Region, c.Regions
+}
+
+func (c *CloudWatch) Defaults(tags string, protect Protect, grace Grace) {
+	defaults(&c.Tags, &c.Protect, tags, protect)
+	c.Grace = c.Grace.or(grace)
+}
+
+func (c *CloudWatch) Validate() error {
+	switch strings.ToLower(c.Mode) {
+	case "", CloudWatchDelete:
+	case CloudWatchEnforce:
+		if !slices.Contains(retentionDays, c.RetentionDays) {
+			return fmt.Errorf("invalid cloudwatch retentionDays %d: expected one of %v", c.RetentionDays, retentionDays)
+		}
+	default:
+		return fmt.Errorf("invalid cloudwatch mode %q: expected %s or %s", c.Mode, CloudWatchDelete, CloudWatchEnforce)
+	}
+
+	if len(c.Prefix) > 0 && c.Pattern != "" {
+		return fmt.Errorf("cloudwatch prefix and pattern are mutually exclusive")
+	}
+
+	if c.Class != "" && !slices.Contains(types.LogGroupClass("").Values(), types.LogGroupClass(strings.ToUpper(c.Class))) {
+		return fmt.Errorf("invalid cloudwatch class %q", c.Class)
+	}
+
+	if _, err := cutoff(c.CreatedBefore); err != nil {
 		return err
 	}
 
-	cwl := cloudwatchlogs.NewFromConfig(cfg)
+	if _, err := c.Retention.olderThan(); err != nil {
+		return fmt.Errorf("invalid cloudwatch retention olderThan: %w", err)
+	}
 
-	err = cloudWatchRateLimit.Wait(ctx)
-	if err != nil {
+	if _, err := c.Retention.idleFor(); err != nil {
+		return fmt.Errorf("invalid cloudwatch retention idleFor: %w", err)
+	}
+
+	if _, err := c.Selector.compile(); err != nil {
+		return err
+	}
+
+	if err := c.Archive.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.Grace.Validate(); err != nil {
 		return err
 	}
 
-	var logGroupNames []string
-	err = getAllLogGroupNames(ctx, cwl, &logGroupNames)
+	return validate(c.Tags, &c.Protect)
+}
+
+func (c *CloudWatch) Reaper(region string, credentials aws.CredentialsProvider) Reaper {
+	cw := *c
+	cw.Region = region
+	cw.Credentials = credentials
+	return cw.reaper()
+}
+
+func (c CloudWatch) reaper() cloudWatchReaper {
+	return cloudWatchReaper{c: c, listed: newListed[listedLogGroup](), account: newCallerAccount(c.Credentials)}
+}
+
+func (r cloudWatchReaper) List(ctx context.Context) ([]string, error) {
+	cwl, err := newCloudWatchClient(ctx, r.c)
+	if err != nil {
+		return nil, err
+	}
+
+	logGroups, err := getAllLogGroups(ctx, cwl, r.c)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.names(logGroups), nil
+}
+
+// Pages lists the log groups one describe page at a time.
+func (r cloudWatchReaper) Pages(ctx context.Context, page func(logGroupNames []string) error) error {
+	cwl, err := newCloudWatchClient(ctx, r.c)
 	if err != nil {
 		return err
 	}
 
+	return pageLogGroups(ctx, cwl, r.c, func(logGroups []types.LogGroup) error {
+		return page(r.names(logGroups))
+	})
+}
+
+// names records the stored bytes and retention of every log group and
+// returns their names.
+func (r cloudWatchReaper) names(logGroups []types.LogGroup) []string {
+	logGroupNames := make([]string, 0, len(logGroups))
+	for _, lg := range logGroups {
+		name := aws.ToString(lg.LogGroupName)
+		r.listed.set(name, listedLogGroup{bytes: aws.ToInt64(lg.StoredBytes), retention: aws.ToInt32(lg.RetentionInDays)})
+		logGroupNames = append(logGroupNames, name)
+	}
+
+	return logGroupNames
+}
+
+func (r cloudWatchReaper) Filter(ctx context.Context, logGroupNames []string) ([]string, error) {
+	cfg, err := r.c.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logGroupNames, err = selectTagged(ctx, cfg, r.c.Tags, tagTypeLogGroup, logGroupNames, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	logGroupNames, err = r.c.Protect.filter(ctx, cfg, r.account, "logs", tagTypeLogGroup, logGroupNames, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.c.enforce() {
+		return r.c.Grace.filter(ctx, cfg, r.account, tagTypeLogGroup, logGroupNames, identity, func(account, name string) (string, error) {
+			return resourceArn(cfg, account, "logs", "log-group:"+name), nil
+		})
+	}
+
+	var noncompliant []string
+	for _, name := range logGroupNames {
+		if lg, ok := r.listed.get(name); ok && lg.retention > 0 && lg.retention <= r.c.RetentionDays {
+			logger.Logger.Debug().Str("logGroup", name).Int32("retentionDays", lg.retention).Msg("skipping log group with compliant retention")
+			skipped(ctx, name, fmt.Sprintf("retention already %d days", lg.retention))
+			continue
+		}
+
+		noncompliant = append(noncompliant, name)
+	}
+
+	return noncompliant, nil
+}
+
+func (r cloudWatchReaper) Delete(ctx context.Context, logGroupNames []string) error {
 	if len(logGroupNames) == 0 {
-		logger.Logger.Warn().Str("region", c.Region).Msg("no log groups found in region")
+		logger.Logger.Warn().Str("region", r.c.Region).Msg("no log groups found in region")
 		return nil
 	}
 
-	for i := 0; i < len(logGroupNames); i += c.BatchSize {
-		end := i + c.BatchSize
-		if end > len(logGroupNames) {
-			end = len(logGroupNames)
+	cwl, err := newCloudWatchClient(ctx, r.c)
+	if err != nil {
+		return err
+	}
+
+	if r.c.enforce() {
+		return r.enforce(ctx, cwl, logGroupNames)
+	}
+
+	var account string
+	if r.c.Archive.enabled() {
+		if account, err = r.account.get(); err != nil {
+			return err
 		}
+	}
 
-		batch := logGroupNames[i:end]
+	failures := deleteBatches(ctx, "log group", r.c.Region, batches(logGroupNames, r.c.BatchSize), r.c.Parallelism, func(ctx context.Context, batch []string) (Failures, error) {
 		logger.Logger.Debug().Strs("logGroups", batch).Msg("destroying log groups")
 
-		for _, logGroupName := range batch {
+		failed := make(Failures)
+		for i, logGroupName := range batch {
+			if err := ctx.Err(); err != nil {
+				failed.fail(err, batch[i:]...)
+				break
+			}
+
+			if r.c.Archive.enabled() {
+				if _, err := r.c.Archive.archive(ctx, cwl, account, r.c.Region, logGroupName); err != nil {
+					logger.Logger.Error().Err(err).Str("logGroup", logGroupName).Msg("error archiving log group. keeping it.")
+					failed.fail(fmt.Errorf("archive: %w", err), logGroupName)
+					continue
+				}
+			}
+
 			_, err := cwl.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{
 				LogGroupName: &logGroupName,
 			})
 			if err != nil {
-				return err
+				logger.Logger.Error().Err(err).Str("logGroup", logGroupName).Msg("error destroying log group")
+				failed.fail(err, logGroupName)
+				continue
 			}
 			logger.Logger.Info().Str("logGroup", logGroupName).Msg("destroyed log group")
 		}
-	}
 
-	return nil
+		return failed, nil
+	})
+
+	return failures.err()
 }
 
-func getAllLogGroupNames(ctx context.Context, cwl *cloudwatchlogs.Client, logGroupNames *[]string) error {
-	input := &cloudwatchlogs.DescribeLogGroupsInput{}
+// enforce sets the retention policy of every log group, logging the groups
+// that kept their events forever until now.
+func (r cloudWatchReaper) enforce(ctx context.Context, cwl *cloudwatchlogs.Client, logGroupNames []string) error {
+	failures := deleteBatches(ctx, "log group retention", r.c.Region, batches(logGroupNames, r.c.BatchSize), r.c.Parallelism, func(ctx context.Context, batch []string) (Failures, error) {
+		failed := make(Failures)
+		for i, logGroupName := range batch {
+			if err := ctx.Err(); err != nil {
+				failed.fail(err, batch[i:]...)
+				break
+			}
 
-	for {
-		resp, err := cwl.DescribeLogGroups(ctx, input)
+			_, err := cwl.PutRetentionPolicy(ctx, &cloudwatchlogs.PutRetentionPolicyInput{
+				LogGroupName:    &logGroupName,
+				RetentionInDays: aws.Int32(r.c.RetentionDays),
+			})
+			if err != nil {
+				logger.Logger.Error().Err(err).Str("logGroup", logGroupName).Msg("error setting log group retention")
+				failed.fail(err, logGroupName)
+				continue
+			}
+
+			e := logger.Logger.Info()
+			if lg, ok := r.listed.get(logGroupName); ok && lg.retention == 0 {
+				e = logger.Logger.Warn().Bool("neverExpired", true)
+			}
+			e.Str("logGroup", logGroupName).
+				Str("previous", r.Previous(logGroupName)).
+				Int32("retentionDays", r.c.RetentionDays).
+				Msg("set log group retention")
+		}
+
+		return failed, nil
+	})
+
+	return failures.err()
+}
+
+// Gone reports the log groups that no longer exist.
+func (r cloudWatchReaper) Gone(ctx context.Context, logGroupNames []string) ([]string, error) {
+	api, err := newCloudWatchClient(ctx, r.c)
+	if err != nil {
+		return nil, err
+	}
+
+	var gone []string
+	for _, logGroupName := range logGroupNames {
+		resp, err := api.DescribeLogGroups(ctx, &cloudwatchlogs.DescribeLogGroupsInput{LogGroupNamePrefix: aws.String(logGroupName)})
 		if err != nil {
-			logger.Logger.Error().Err(err).Msg("error describing log groups")
-			return err
+			logger.Logger.Error().Err(err).Str("logGroup", logGroupName).Msg("error describing log group")
+			return nil, err
 		}
 
-		for _, lg := range resp.LogGroups {
-			*logGroupNames = append(*logGroupNames, *lg.LogGroupName)
+		if !slices.ContainsFunc(resp.LogGroups, func(lg types.LogGroup) bool {
+			return aws.ToString(lg.LogGroupName) == logGroupName
+		}) {
+			gone = append(gone, logGroupName)
+		}
+	}
+
+	return gone, nil
+}
+
+func (r cloudWatchReaper) Describe(logGroupName string) string {
+	if r.c.enforce() {
+		return fmt.Sprintf("cloudwatch log group %s retention %s to %d days", logGroupName, r.Previous(logGroupName), r.c.RetentionDays)
+	}
+	return fmt.Sprintf("cloudwatch log group %s", logGroupName)
+}
+
+// Previous describes the retention a log group had when it was listed, when
+// enforcing retention.
+func (r cloudWatchReaper) Previous(logGroupName string) string {
+	lg, ok := r.listed.get(logGroupName)
+	switch {
+	case !r.c.enforce():
+		return ""
+	case !ok:
+		return "unknown"
+	case lg.retention == 0:
+		return "never expire"
+	default:
+		return fmt.Sprintf("%d days", lg.retention)
+	}
+}
+
+// Size is zero when enforcing retention, since the stored bytes are only
+// reclaimed as events expire.
+func (r cloudWatchReaper) Size(logGroupName string) int64 {
+	if r.c.enforce() {
+		return 0
+	}
+	lg, _ := r.listed.get(logGroupName)
+	return lg.bytes
+}
+
+func (r cloudWatchReaper) Forget(logGroupNames []string) {
+	r.listed.forget(logGroupNames)
+}
+
+func (c CloudWatch) enforce() bool {
+	return strings.EqualFold(c.Mode, CloudWatchEnforce)
+}
+
+func newCloudWatchClient(ctx context.Context, c CloudWatch) (*cloudwatchlogs.Client, error) {
+	cfg, err := c.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return cloudwatchlogs.NewFromConfig(cfg), nil
+}
+
+func getAllLogGroups(ctx context.Context, cwl *cloudwatchlogs.Client, c CloudWatch) ([]types.LogGroup, error) {
+	var logGroups []types.LogGroup
+
+	err := pageLogGroups(ctx, cwl, c, func(page []types.LogGroup) error {
+		logGroups = append(logGroups, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return logGroups, nil
+}
+
+// pageLogGroups calls page with the matching log groups of every describe
+// page.
+func pageLogGroups(ctx context.Context, cwl *cloudwatchlogs.Client, c CloudWatch, page func(logGroups []types.LogGroup) error) error {
+	m, err := c.Selector.compile()
+	if err != nil {
+		return err
+	}
+
+	before, err := cutoff(c.CreatedBefore)
+	if err != nil {
+		return err
+	}
+
+	idle, err := c.Retention.idleFor()
+	if err != nil {
+		return err
+	}
+
+	var inputs []*cloudwatchlogs.DescribeLogGroupsInput
+	if len(c.Prefix) > 0 {
+		for _, prefix := range c.Prefix {
+			inputs = append(inputs, &cloudwatchlogs.DescribeLogGroupsInput{
+				LogGroupNamePrefix: aws.String(prefix),
+				LogGroupClass:      types.LogGroupClass(strings.ToUpper(c.Class)),
+			})
 		}
+	} else {
+		input := &cloudwatchlogs.DescribeLogGroupsInput{
+			LogGroupClass: types.LogGroupClass(strings.ToUpper(c.Class)),
+		}
+		if c.Pattern != "" {
+			input.LogGroupNamePattern = aws.String(c.Pattern)
+		}
+		inputs = append(inputs, input)
+	}
+
+	// only overlapping prefixes can list a log group twice, so a single
+	// listing does not need to remember every name it saw.
+	seen := make(map[string]bool)
+	dedupe := len(inputs) > 1
+
+	for _, input := range inputs {
+		for {
+			resp, err := cwl.DescribeLogGroups(ctx, input)
+			if err != nil {
+				logger.Logger.Error().Err(err).Msg("error describing log groups")
+				return err
+			}
 
-		if resp.NextToken == nil {
-			break
+			var logGroups []types.LogGroup
+			for _, lg := range resp.LogGroups {
+				name := *lg.LogGroupName
+				if seen[name] || !m.matches(name) {
+					continue
+				}
+
+				if !before.IsZero() && lg.CreationTime != nil && !time.UnixMilli(*lg.CreationTime).Before(before) {
+					logger.Logger.Debug().Str("logGroup", name).Msg("skipping log group created after cutoff")
+					skipped(ctx, name, "createdBefore "+c.CreatedBefore)
+					continue
+				}
+
+				if !idle.IsZero() {
+					last, err := lastEvent(ctx, cwl, lg)
+					if err != nil {
+						return err
+					}
+
+					if last.After(idle) {
+						logger.Logger.Debug().Str("logGroup", name).Str("idleFor", c.Retention.IdleFor).Msg("retaining log group with recent events")
+						skipped(ctx, name, "retention idleFor "+c.Retention.IdleFor)
+						continue
+					}
+				}
+
+				if dedupe {
+					seen[name] = true
+				}
+				logGroups = append(logGroups, lg)
+			}
+
+			if err := page(logGroups); err != nil {
+				return err
+			}
+
+			if resp.NextToken == nil {
+				break
+			}
+			input.NextToken = resp.NextToken
 		}
-		input.NextToken = resp.NextToken
 	}
 
 	return nil
 }
+
+// lastEvent returns the time of the newest event in a log group, falling back
+// to the group's creation time when it holds no events.
+func lastEvent(ctx context.Context, cwl *cloudwatchlogs.Client, lg types.LogGroup) (time.Time, error) {
+	resp, err := cwl.DescribeLogStreams(ctx, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName: lg.LogGroupName,
+		OrderBy:      types.OrderByLastEventTime,
+		Descending:   aws.Bool(true),
+		Limit:        aws.Int32(1),
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("logGroup", *lg.LogGroupName).Msg("error describing log streams")
+		return time.Time{}, err
+	}
+
+	if len(resp.LogStreams) > 0 && resp.LogStreams[0].LastEventTimestamp != nil {
+		return time.UnixMilli(*resp.LogStreams[0].LastEventTimestamp), nil
+	}
+
+	if lg.CreationTime != nil {
+		return time.UnixMilli(*lg.CreationTime), nil
+	}
+
+	return time.Time{}, nil
+}
+
+func (c CloudWatch) config(ctx context.Context) (aws.Config, error) {
+	return loadConfig(ctx, c.Region, c.Retry, c.Credentials)
+}
@@ -0,0 +1,195 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeLogs serves DescribeLogGroups in pages of size groups and records
+// every PutRetentionPolicy.
+type fakeLogs struct {
+	pages int
+	size  int
+
+	mu  sync.Mutex
+	put map[string]int32
+}
+
+func (f *fakeLogs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	switch op := r.Header.Get("X-Amz-Target"); {
+	case strings.HasSuffix(op, ".DescribeLogGroups"):
+		page := 0
+		if token, ok := body["nextToken"].(string); ok {
+			page, _ = strconv.Atoi(token)
+		}
+
+		var groups []map[string]any
+		for i := range f.size {
+			groups = append(groups, map[string]any{
+				"logGroupName":    fmt.Sprintf("/tidy/%d/%d", page, i),
+				"retentionInDays": i % 3 * 30,
+				"storedBytes":     i,
+			})
+		}
+
+		resp := map[string]any{"logGroups": groups}
+		if page+1 < f.pages {
+			resp["nextToken"] = strconv.Itoa(page + 1)
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	case strings.HasSuffix(op, ".PutRetentionPolicy"):
+		f.mu.Lock()
+		f.put[body["logGroupName"].(string)] = int32(body["retentionInDays"].(float64))
+		f.mu.Unlock()
+		_, _ = w.Write([]byte("{}"))
+	default:
+		http.Error(w, "unexpected "+op, http.StatusBadRequest)
+	}
+}
+
+func TestStreamEnforce(t *testing.T) {
+	f := &fakeLogs{pages: 20, size: 25, put: make(map[string]int32)}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	SetRateLimits(map[string]RateLimit{"default": {Rate: 100000, Burst: 100000}})
+
+	c := CloudWatch{Region: "us-west-2", Mode: CloudWatchEnforce, RetentionDays: 30, BatchSize: 5, Parallelism: 4}
+	r := c.reaper()
+
+	var mu sync.Mutex
+	var changed []string
+	count, err := Stream(context.Background(), "cloudwatch", c.Region, r, Hooks{
+		Planned: func(items []string) {
+			for _, item := range items {
+				_ = r.Previous(item)
+				_ = r.Size(item)
+			}
+		},
+		Deleting: func([]string) {},
+		Deleted: func(items []string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			changed = append(changed, items...)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// groups already keeping events 30 days are compliant, every other group
+	// either never expired or kept them 60 days.
+	want := 0
+	for i := range f.size {
+		if i%3 != 1 {
+			want += f.pages
+		}
+	}
+	if count != want || len(changed) != want || len(f.put) != want {
+		t.Fatalf("planned %d, changed %d, put %d, want %d", count, len(changed), len(f.put), want)
+	}
+
+	for name, days := range f.put {
+		if days != 30 {
+			t.Errorf("%s retention %d, want 30", name, days)
+		}
+	}
+}
+
+// listedStub lists log groups without calling aws, and deletes by reading
+// what listing recorded, so nothing but the reaper orders the two.
+type listedStub struct {
+	cloudWatchReaper
+	pages [][]types.LogGroup
+}
+
+func (s listedStub) Pages(ctx context.Context, page func(logGroupNames []string) error) error {
+	for _, lg := range s.pages {
+		if err := page(s.names(lg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s listedStub) Filter(_ context.Context, logGroupNames []string) ([]string, error) {
+	return logGroupNames, nil
+}
+
+func (s listedStub) Delete(_ context.Context, logGroupNames []string) error {
+	for _, name := range logGroupNames {
+		if s.Previous(name) == "unknown" {
+			return fmt.Errorf("%s was not listed", name)
+		}
+	}
+	return nil
+}
+
+func TestStreamListedConcurrently(t *testing.T) {
+	c := CloudWatch{Mode: CloudWatchEnforce, RetentionDays: 30}
+	s := listedStub{cloudWatchReaper: c.reaper()}
+
+	for page := range 50 {
+		var groups []types.LogGroup
+		for i := range 10 {
+			groups = append(groups, types.LogGroup{
+				LogGroupName:    aws.String(fmt.Sprintf("/tidy/%d/%d", page, i)),
+				RetentionInDays: aws.Int32(int32(i)),
+			})
+		}
+		s.pages = append(s.pages, groups)
+	}
+
+	count, err := Stream(context.Background(), "cloudwatch", "us-west-2", s, Hooks{
+		Planned:  func([]string) {},
+		Deleting: func([]string) {},
+		Deleted:  func([]string, error) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 500 {
+		t.Fatalf("planned %d, want 500", count)
+	}
+}
+
+func TestCloudWatchValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		c    CloudWatch
+		err  bool
+	}{
+		{name: "defaults"},
+		{name: "class", c: CloudWatch{Class: "infrequent_access", CreatedBefore: "2026-01-01", Retention: Retention{IdleFor: "30d"}}},
+		{name: "prefix and pattern", c: CloudWatch{Prefix: []string{"/aws/"}, Pattern: "lambda"}, err: true},
+		{name: "unknown class", c: CloudWatch{Class: "archive"}, err: true},
+		{name: "bad createdBefore", c: CloudWatch{CreatedBefore: "last week"}, err: true},
+		{name: "bad idleFor", c: CloudWatch{Retention: Retention{IdleFor: "30x"}}, err: true},
+		{name: "bad regex", c: CloudWatch{Selector: Selector{IncludeRegex: []string{"("}}}, err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.c.Validate(); (err != nil) != tc.err {
+				t.Errorf("Validate() error %v, want error %v", err, tc.err)
+			}
+		})
+	}
+}
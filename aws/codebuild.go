@@ -2,96 +2,426 @@ package aws
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/codebuild"
+	"github.com/aws/aws-sdk-go-v2/service/codebuild/types"
+	"github.com/aws/smithy-go"
 	"github.com/stxkxs/ok-cli/logger"
-	"golang.org/x/time/rate"
+	"slices"
 	"strings"
+	"time"
 )
 
+// CodeBuild deletes the build history of the projects whose names match
+// Prefix and the selector. Builds are listed per project, so each build is
+// deleted once, and Status limits deletion to builds that ended in one of the
+// given statuses, e.g. FAILED or STOPPED. Reports prunes test and coverage
+// reports, and StaleProjects optionally deletes whole projects that have not
+// built for that long, whatever the status of their builds, along with the
+// builds retention kept.
 type CodeBuild struct {
-	Region    string   `mapstructure:"region"`
-	BatchSize int      `mapstructure:"batchSize"`
-	Prefix    []string `mapstructure:"prefix"`
-	Retry     int      `mapstructure:"retry"`
+	Region        string           `mapstructure:"region"`
+	Regions       []string         `mapstructure:"regions"`
+	BatchSize     int              `mapstructure:"batchSize"`
+	Parallelism   int              `mapstructure:"parallelism"`
+	Prefix        []string         `mapstructure:"prefix"`
+	Status        []string         `mapstructure:"status"`
+	Retry         int              `mapstructure:"retry"`
+	Retention     Retention        `mapstructure:"retention"`
+	Reports       CodeBuildReports `mapstructure:"reports"`
+	StaleProjects string           `mapstructure:"staleProjects"`
+	Tags          string           `mapstructure:"tags"`
+	Protect       Protect          `mapstructure:"protect"`
+	Grace         Grace            `mapstructure:"grace"`
+	Selector      `mapstructure:",squash"`
+	Credentials   aws.CredentialsProvider `mapstructure:"-" json:"-"`
 }
 
-var codeBuildRateLimit = rate.NewLimiter(rate.Limit(10), 10)
+// CodeBuildReports deletes the reports created before OlderThan from the
+// report groups whose names match the section's prefix and selector. With
+// Groups, a report group holding no newer report is deleted whole, along
+// with its reports. Nothing is pruned without OlderThan.
+type CodeBuildReports struct {
+	OlderThan string `mapstructure:"olderThan"`
+	Groups    bool   `mapstructure:"groups"`
+}
+
+const (
+	batchGetBuildsLimit  = 100
+	batchGetReportsLimit = 100
+
+	// items of the section are build ids, or arns of the other kinds.
+	codeBuildBuild       = "build"
+	codeBuildReport      = "report"
+	codeBuildReportGroup = "report-group"
+	codeBuildProject     = "project"
+)
+
+// codeBuildKinds orders deletion: builds and reports go before the report
+// groups and projects that own them.
+var codeBuildKinds = []string{codeBuildBuild, codeBuildReport, codeBuildReportGroup, codeBuildProject}
+
+// codeBuildReaper counts the builds it kept per project, so KeepLast holds
+// across pages when builds are filtered page by page.
+type codeBuildReaper struct {
+	c       CodeBuild
+	kept    map[string]int
+	account *callerAccount
+}
 
 func DestroyBuildHistory(c CodeBuild) error {
-	ctx := context.Background()
+	_, err := Reap(context.Background(), c.reaper())
+	return err
+}
+
+func ListBuildHistory(c CodeBuild) ([]string, error) {
+	return Candidates(context.Background(), c.reaper())
+}
 
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(c.Region), config.WithRetryMaxAttempts(c.Retry))
+func DeleteBuilds(c CodeBuild, builds []string) error {
+	return c.reaper().Delete(context.Background(), builds)
+}
+
+func (c *CodeBuild) Scope() (string, []string) {
+	return c.Region, c.Regions
+}
+
+func (c *CodeBuild) Defaults(tags string, protect Protect, grace Grace) {
+	defaults(&c.Tags, &c.Protect, tags, protect)
+	c.Grace = c.Grace.or(grace)
+}
+
+func (c *CodeBuild) Validate() error {
+	for _, s := range c.Status {
+		if !slices.Contains(types.StatusType("").Values(), types.StatusType(strings.ToUpper(s))) {
+			return fmt.Errorf("invalid codebuild build status %q", s)
+		}
+	}
+
+	if err := c.Grace.Validate(); err != nil {
+		return err
+	}
+
+	return validate(c.Tags, &c.Protect)
+}
+
+func (c *CodeBuild) Reaper(region string, credentials aws.CredentialsProvider) Reaper {
+	cb := *c
+	cb.Region = region
+	cb.Credentials = credentials
+	return cb.reaper()
+}
+
+func (c CodeBuild) reaper() codeBuildReaper {
+	return codeBuildReaper{c: c, kept: make(map[string]int), account: newCallerAccount(c.Credentials)}
+}
+
+func (r codeBuildReaper) List(ctx context.Context) ([]string, error) {
+	var items []string
+
+	err := r.Pages(ctx, func(page []string) error {
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// Pages lists the builds of every selected project one page at a time,
+// newest first, followed by the project itself when it is stale, and then
+// the expired reports.
+func (r codeBuildReaper) Pages(ctx context.Context, page func(items []string) error) error {
+	cb, err := newCodeBuildClient(ctx, r.c)
 	if err != nil {
-		logger.Logger.Error().Err(err).Msg("error loading default aws configurations")
 		return err
 	}
 
-	cb := codebuild.NewFromConfig(cfg)
-	input := &codebuild.ListBuildsInput{}
+	m, err := r.c.Selector.compile()
+	if err != nil {
+		return err
+	}
 
-	err = codeBuildRateLimit.Wait(ctx)
+	stale, err := before(r.c.StaleProjects)
 	if err != nil {
 		return err
 	}
 
-	builds, err := getAllBuilds(ctx, cb, input, c.Prefix)
+	projects, err := r.c.projects(ctx, cb, m)
 	if err != nil {
 		return err
 	}
 
-	err, done := maybeDeleteBuilds(c, builds, cb, ctx)
-	if done {
+	for _, name := range projects {
+		latest, err := pageBuilds(ctx, cb, name, page)
+		if err != nil {
+			return err
+		}
+
+		if stale.IsZero() {
+			continue
+		}
+
+		arn, idle, err := staleProject(ctx, cb, name, latest, stale)
+		if err != nil {
+			return err
+		}
+
+		if !idle {
+			logger.Logger.Debug().Str("project", name).Str("staleProjects", r.c.StaleProjects).Msg("retaining project with recent builds")
+			continue
+		}
+
+		if err := page([]string{arn}); err != nil {
+			return err
+		}
+	}
+
+	return r.c.pageReports(ctx, cb, m, page)
+}
+
+// Filter applies the tag selector and protect rules to the project or report
+// group owning each item, the retention rules to builds, and the grace period
+// to projects and report groups, the only items that can carry the mark.
+func (r codeBuildReaper) Filter(ctx context.Context, items []string) ([]string, error) {
+	cfg, err := r.c.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := byCodeBuildKind(items)
+
+	var kept []string
+	for _, kind := range codeBuildKinds {
+		selected := kinds[kind]
+		if len(selected) == 0 {
+			continue
+		}
+
+		tagType := tagTypeProject
+		if kind == codeBuildReport || kind == codeBuildReportGroup {
+			tagType = tagTypeReportGroup
+		}
+
+		selected, err = selectTagged(ctx, cfg, r.c.Tags, tagType, selected, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		selected, err = r.c.Protect.filter(ctx, cfg, r.account, "codebuild", tagType, selected, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case codeBuildBuild:
+			selected, err = retainBuilds(ctx, codebuild.NewFromConfig(cfg), selected, r.c.Retention, r.c.Status, r.kept)
+		case codeBuildProject, codeBuildReportGroup:
+			selected, err = r.c.Grace.filter(ctx, cfg, r.account, tagType, selected, owner, func(account, name string) (string, error) {
+				return resourceArn(cfg, account, "codebuild", kind+"/"+name), nil
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		kept = append(kept, selected...)
+	}
+
+	return kept, nil
+}
+
+// Delete removes builds first and the projects and report groups owning
+// them last.
+func (r codeBuildReaper) Delete(ctx context.Context, items []string) error {
+	if len(items) == 0 {
+		logger.Logger.Warn().Str("region", r.c.Region).Msg("no builds found in region")
+		return nil
+	}
+
+	cb, err := newCodeBuildClient(ctx, r.c)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	kinds := byCodeBuildKind(items)
+	failures := make(Failures)
+
+	for _, kind := range codeBuildKinds {
+		if len(kinds[kind]) == 0 {
+			continue
+		}
+
+		if kind == codeBuildBuild {
+			failures.merge(kinds[kind], maybeDeleteBuilds(r.c, kinds[kind], cb, ctx))
+			continue
+		}
+
+		failures.merge(kinds[kind], deleteCodeBuild(ctx, r.c, cb, kind, kinds[kind]))
+	}
+
+	return failures.err()
 }
 
-func maybeDeleteBuilds(c CodeBuild, builds []string, cb *codebuild.Client, ctx context.Context) (error, bool) {
-	if len(builds) == 0 {
-		logger.Logger.Warn().Str("region", c.Region).Msg("no builds found in region")
-		return nil, true
+// Gone reports the builds, reports, report groups, and projects that no
+// longer exist.
+func (r codeBuildReaper) Gone(ctx context.Context, items []string) ([]string, error) {
+	cb, err := newCodeBuildClient(ctx, r.c)
+	if err != nil {
+		return nil, err
 	}
 
-	for i := 0; i < len(builds); i += c.BatchSize {
-		end := i + c.BatchSize
-		if end > len(builds) {
-			end = len(builds)
+	var gone []string
+	for kind, batch := range byCodeBuildKind(items) {
+		for i := 0; i < len(batch); i += batchGetBuildsLimit {
+			end := min(i+batchGetBuildsLimit, len(batch))
+
+			found, err := codeBuildGone(ctx, cb, kind, batch[i:end])
+			if err != nil {
+				logger.Logger.Error().Err(err).Msgf("error getting codebuild %ss", kind)
+				return nil, err
+			}
+			gone = append(gone, found...)
 		}
+	}
+
+	return gone, nil
+}
 
-		batch := builds[i:end]
+func (r codeBuildReaper) Describe(item string) string {
+	switch codeBuildKind(item) {
+	case codeBuildReport:
+		return fmt.Sprintf("codebuild report %s of report group %s", item, owner(item))
+	case codeBuildReportGroup:
+		return fmt.Sprintf("codebuild report group %s", owner(item))
+	case codeBuildProject:
+		return fmt.Sprintf("codebuild project %s", owner(item))
+	default:
+		return fmt.Sprintf("codebuild build %s of project %s", item, project(item))
+	}
+}
+
+func newCodeBuildClient(ctx context.Context, c CodeBuild) (*codebuild.Client, error) {
+	cfg, err := c.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return codebuild.NewFromConfig(cfg), nil
+}
+
+func maybeDeleteBuilds(c CodeBuild, builds []string, cb *codebuild.Client, ctx context.Context) error {
+	if len(builds) == 0 {
+		logger.Logger.Warn().Str("region", c.Region).Msg("no builds found in region")
+		return nil
+	}
+
+	failures := deleteBatches(ctx, "build", c.Region, batches(builds, c.BatchSize), c.Parallelism, func(ctx context.Context, batch []string) (Failures, error) {
 		logger.Logger.Debug().Strs("ids", batch).Msg("deleting builds")
 
 		deleted, err := cb.BatchDeleteBuilds(ctx, &codebuild.BatchDeleteBuildsInput{Ids: batch})
 		if err != nil {
-			return err, true
+			logger.Logger.Error().Err(err).Strs("ids", batch).Msg("error deleting builds")
+			return nil, err
+		}
+
+		failed := make(Failures)
+		for _, b := range deleted.BuildsNotDeleted {
+			failed.fail(&smithy.GenericAPIError{Code: aws.ToString(b.StatusCode)}, aws.ToString(b.Id))
 		}
 
 		logger.Logger.Info().
 			Strs("ids", batch).
 			Interface("deleted", deleted).
 			Msg("deleted builds")
-	}
 
-	return nil, false
+		return failed, nil
+	})
+
+	return failures.err()
+}
+
+// deleteCodeBuild deletes reports, report groups, or projects one at a time,
+// since codebuild has no batch delete for them. Report groups are deleted
+// along with any reports left in them.
+func deleteCodeBuild(ctx context.Context, c CodeBuild, cb *codebuild.Client, kind string, items []string) error {
+	resource := strings.ReplaceAll(kind, "-", " ")
+
+	failures := deleteBatches(ctx, resource, c.Region, batches(items, 1), c.Parallelism, func(ctx context.Context, batch []string) (Failures, error) {
+		item := batch[0]
+
+		var err error
+		switch kind {
+		case codeBuildReport:
+			_, err = cb.DeleteReport(ctx, &codebuild.DeleteReportInput{Arn: &item})
+		case codeBuildReportGroup:
+			_, err = cb.DeleteReportGroup(ctx, &codebuild.DeleteReportGroupInput{Arn: &item, DeleteReports: true})
+		case codeBuildProject:
+			if err = deleteProjectBuilds(ctx, cb, owner(item)); err == nil {
+				_, err = cb.DeleteProject(ctx, &codebuild.DeleteProjectInput{Name: aws.String(owner(item))})
+			}
+		default:
+			err = fmt.Errorf("unknown codebuild item %s", item)
+		}
+
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("arn", item).Msgf("error deleting codebuild %s", resource)
+			return nil, err
+		}
+
+		logger.Logger.Info().Str("arn", item).Msgf("deleted codebuild %s", resource)
+		return nil, nil
+	})
+
+	return failures.err()
 }
 
-func getAllBuilds(ctx context.Context, cb *codebuild.Client, input *codebuild.ListBuildsInput, prefixes []string) ([]string, error) {
+// deleteProjectBuilds deletes the builds left in a project, those retention
+// kept, since deleting a project leaves its builds behind.
+func deleteProjectBuilds(ctx context.Context, cb *codebuild.Client, name string) error {
 	var builds []string
+	_, err := pageBuilds(ctx, cb, name, func(ids []string) error {
+		builds = append(builds, ids...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches(builds, batchGetBuildsLimit) {
+		deleted, err := cb.BatchDeleteBuilds(ctx, &codebuild.BatchDeleteBuildsInput{Ids: batch})
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("project", name).Msg("error deleting builds of codebuild project")
+			return err
+		}
+
+		if len(deleted.BuildsNotDeleted) > 0 {
+			b := deleted.BuildsNotDeleted[0]
+			return fmt.Errorf("build %s of project %s not deleted: %s", aws.ToString(b.Id), name, aws.ToString(b.StatusCode))
+		}
+	}
+
+	return nil
+}
+
+// projects lists the names of the projects matching the prefix and selector.
+func (c CodeBuild) projects(ctx context.Context, cb *codebuild.Client, m *matcher) ([]string, error) {
+	var projects []string
+	input := &codebuild.ListProjectsInput{SortBy: types.ProjectSortByTypeName}
 
 	for {
-		found, err := cb.ListBuilds(ctx, input)
+		found, err := cb.ListProjects(ctx, input)
 		if err != nil {
-			logger.Logger.Error().Err(err).Msg("error listing codebuild builds")
+			logger.Logger.Error().Err(err).Msg("error listing codebuild projects")
 			return nil, err
 		}
 
-		for _, id := range found.Ids {
-			for _, prefix := range prefixes {
-				if strings.HasPrefix(id, prefix) {
-					builds = append(builds, id)
-				}
+		for _, name := range found.Projects {
+			if matchesPrefix(name, c.Prefix) && m.matches(name) {
+				projects = append(projects, name)
 			}
 		}
 
@@ -102,5 +432,367 @@ func getAllBuilds(ctx context.Context, cb *codebuild.Client, input *codebuild.Li
 		input.NextToken = found.NextToken
 	}
 
-	return builds, nil
+	return projects, nil
+}
+
+// pageBuilds calls page with every list page of the builds of a project,
+// newest first, and returns the newest build.
+func pageBuilds(ctx context.Context, cb *codebuild.Client, name string, page func(builds []string) error) (string, error) {
+	var latest string
+	input := &codebuild.ListBuildsForProjectInput{ProjectName: &name, SortOrder: types.SortOrderTypeDescending}
+
+	for {
+		found, err := cb.ListBuildsForProject(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("project", name).Msg("error listing codebuild builds")
+			return "", err
+		}
+
+		if latest == "" && len(found.Ids) > 0 {
+			latest = found.Ids[0]
+		}
+
+		if err := page(found.Ids); err != nil {
+			return "", err
+		}
+
+		if found.NextToken == nil {
+			break
+		}
+
+		input.NextToken = found.NextToken
+	}
+
+	return latest, nil
+}
+
+// staleProject returns the arn of a project and whether it was neither
+// changed nor built since the cutoff.
+func staleProject(ctx context.Context, cb *codebuild.Client, name, latest string, cutoff time.Time) (string, bool, error) {
+	found, err := cb.BatchGetProjects(ctx, &codebuild.BatchGetProjectsInput{Names: []string{name}})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("project", name).Msg("error getting codebuild project")
+		return "", false, err
+	}
+
+	if len(found.Projects) == 0 {
+		return "", false, nil
+	}
+
+	p := found.Projects[0]
+	last := aws.ToTime(p.Created)
+	if modified := aws.ToTime(p.LastModified); modified.After(last) {
+		last = modified
+	}
+
+	if latest != "" {
+		builds, err := cb.BatchGetBuilds(ctx, &codebuild.BatchGetBuildsInput{Ids: []string{latest}})
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("project", name).Msg("error getting codebuild builds")
+			return "", false, err
+		}
+
+		for _, b := range builds.Builds {
+			if started := aws.ToTime(b.StartTime); started.After(last) {
+				last = started
+			}
+		}
+	}
+
+	return aws.ToString(p.Arn), last.Before(cutoff), nil
+}
+
+// pageReports calls page with the expired reports of every report group
+// matching the prefix and selector, a page per list page.
+func (c CodeBuild) pageReports(ctx context.Context, cb *codebuild.Client, m *matcher, page func(items []string) error) error {
+	older, err := before(c.Reports.OlderThan)
+	if err != nil || older.IsZero() {
+		return err
+	}
+
+	input := &codebuild.ListReportGroupsInput{}
+
+	for {
+		found, err := cb.ListReportGroups(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("error listing codebuild report groups")
+			return err
+		}
+
+		for _, group := range found.ReportGroups {
+			name := nameFromArn(group)
+			if !matchesPrefix(name, c.Prefix) || !m.matches(name) {
+				continue
+			}
+
+			if err := c.pageGroupReports(ctx, cb, group, older, page); err != nil {
+				return err
+			}
+		}
+
+		if found.NextToken == nil {
+			break
+		}
+
+		input.NextToken = found.NextToken
+	}
+
+	return nil
+}
+
+// pageGroupReports lists the reports of a report group created before older,
+// newest first. With Reports.Groups, a group created before older whose
+// newest report is expired as well is listed instead of its reports.
+func (c CodeBuild) pageGroupReports(ctx context.Context, cb *codebuild.Client, group string, older time.Time, page func(items []string) error) error {
+	input := &codebuild.ListReportsForReportGroupInput{
+		ReportGroupArn: &group,
+		SortOrder:      types.SortOrderTypeDescending,
+		MaxResults:     aws.Int32(batchGetReportsLimit),
+	}
+
+	for first := true; ; first = false {
+		found, err := cb.ListReportsForReportGroup(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("reportGroup", group).Msg("error listing codebuild reports")
+			return err
+		}
+
+		created, err := reportsCreated(ctx, cb, found.Reports)
+		if err != nil {
+			return err
+		}
+
+		if first && c.Reports.Groups && (len(found.Reports) == 0 || created[found.Reports[0]].Before(older)) {
+			idle, err := groupCreatedBefore(ctx, cb, group, older)
+			if err != nil {
+				return err
+			}
+
+			if idle {
+				return page([]string{group})
+			}
+		}
+
+		var expired []string
+		for _, report := range found.Reports {
+			if t, ok := created[report]; !ok || !t.Before(older) {
+				logger.Logger.Debug().Str("report", report).Str("olderThan", c.Reports.OlderThan).Msg("retaining report newer than cutoff")
+				skipped(ctx, report, "reports olderThan "+c.Reports.OlderThan)
+				continue
+			}
+
+			expired = append(expired, report)
+		}
+
+		if err := page(expired); err != nil {
+			return err
+		}
+
+		if found.NextToken == nil {
+			break
+		}
+
+		input.NextToken = found.NextToken
+	}
+
+	return nil
+}
+
+// reportsCreated returns when each report was created.
+func reportsCreated(ctx context.Context, cb *codebuild.Client, reports []string) (map[string]time.Time, error) {
+	created := make(map[string]time.Time, len(reports))
+
+	for i := 0; i < len(reports); i += batchGetReportsLimit {
+		end := min(i+batchGetReportsLimit, len(reports))
+
+		found, err := cb.BatchGetReports(ctx, &codebuild.BatchGetReportsInput{ReportArns: reports[i:end]})
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("error getting codebuild reports")
+			return nil, err
+		}
+
+		for _, r := range found.Reports {
+			if r.Arn != nil && r.Created != nil {
+				created[*r.Arn] = *r.Created
+			}
+		}
+	}
+
+	return created, nil
+}
+
+func groupCreatedBefore(ctx context.Context, cb *codebuild.Client, group string, older time.Time) (bool, error) {
+	found, err := cb.BatchGetReportGroups(ctx, &codebuild.BatchGetReportGroupsInput{ReportGroupArns: []string{group}})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("reportGroup", group).Msg("error getting codebuild report group")
+		return false, err
+	}
+
+	for _, g := range found.ReportGroups {
+		last := aws.ToTime(g.Created)
+		if modified := aws.ToTime(g.LastModified); modified.After(last) {
+			last = modified
+		}
+		return !last.IsZero() && last.Before(older), nil
+	}
+
+	return false, nil
+}
+
+// retainBuilds drops builds protected by the retention rules and builds that
+// did not end in one of the given statuses. builds must be ordered newest
+// first so the first KeepLast builds of each project are kept, whatever
+// their status. kept counts the builds already kept per project by earlier
+// pages.
+func retainBuilds(ctx context.Context, cb *codebuild.Client, builds []string, r Retention, status []string, kept map[string]int) ([]string, error) {
+	older, err := r.olderThan()
+	if err != nil {
+		return nil, err
+	}
+
+	if older.IsZero() && r.KeepLast <= 0 && len(status) == 0 {
+		return builds, nil
+	}
+
+	found := make(map[string]types.Build)
+	if !older.IsZero() || len(status) > 0 {
+		for i := 0; i < len(builds); i += batchGetBuildsLimit {
+			end := min(i+batchGetBuildsLimit, len(builds))
+
+			resp, err := cb.BatchGetBuilds(ctx, &codebuild.BatchGetBuildsInput{Ids: builds[i:end]})
+			if err != nil {
+				logger.Logger.Error().Err(err).Msg("error getting codebuild builds")
+				return nil, err
+			}
+
+			for _, b := range resp.Builds {
+				if b.Id != nil {
+					found[*b.Id] = b
+				}
+			}
+		}
+	}
+
+	var expired []string
+
+	for _, id := range builds {
+		p := project(id)
+		if kept[p] < r.KeepLast {
+			kept[p]++
+			logger.Logger.Debug().Str("id", id).Int("keepLast", r.KeepLast).Msg("retaining recent build")
+			skipped(ctx, id, fmt.Sprintf("retention keepLast %d", r.KeepLast))
+			continue
+		}
+
+		b, ok := found[id]
+
+		if len(status) > 0 && (!ok || !slices.ContainsFunc(status, func(s string) bool { return strings.EqualFold(s, string(b.BuildStatus)) })) {
+			logger.Logger.Debug().Str("id", id).Str("status", string(b.BuildStatus)).Msg("retaining build with unselected status")
+			skipped(ctx, id, "status "+string(b.BuildStatus))
+			continue
+		}
+
+		if !older.IsZero() && (!ok || b.StartTime == nil || b.StartTime.After(older)) {
+			logger.Logger.Debug().Str("id", id).Str("olderThan", r.OlderThan).Msg("retaining build newer than cutoff")
+			skipped(ctx, id, "retention olderThan "+r.OlderThan)
+			continue
+		}
+
+		expired = append(expired, id)
+	}
+
+	return expired, nil
+}
+
+// codeBuildGone returns the items of one kind codebuild no longer finds.
+// Projects are looked up by name and answered by name, so they are mapped
+// back to their arns.
+func codeBuildGone(ctx context.Context, cb *codebuild.Client, kind string, items []string) ([]string, error) {
+	switch kind {
+	case codeBuildBuild:
+		resp, err := cb.BatchGetBuilds(ctx, &codebuild.BatchGetBuildsInput{Ids: items})
+		if err != nil {
+			return nil, err
+		}
+		return resp.BuildsNotFound, nil
+	case codeBuildReport:
+		resp, err := cb.BatchGetReports(ctx, &codebuild.BatchGetReportsInput{ReportArns: items})
+		if err != nil {
+			return nil, err
+		}
+		return resp.ReportsNotFound, nil
+	case codeBuildReportGroup:
+		resp, err := cb.BatchGetReportGroups(ctx, &codebuild.BatchGetReportGroupsInput{ReportGroupArns: items})
+		if err != nil {
+			return nil, err
+		}
+		return resp.ReportGroupsNotFound, nil
+	case codeBuildProject:
+		arns := make(map[string]string, len(items))
+		names := make([]string, len(items))
+		for i, item := range items {
+			names[i] = owner(item)
+			arns[names[i]] = item
+		}
+
+		resp, err := cb.BatchGetProjects(ctx, &codebuild.BatchGetProjectsInput{Names: names})
+		if err != nil {
+			return nil, err
+		}
+
+		var gone []string
+		for _, name := range resp.ProjectsNotFound {
+			if arn, ok := arns[name]; ok {
+				gone = append(gone, arn)
+			}
+		}
+		return gone, nil
+	default:
+		return nil, nil
+	}
+}
+
+// codeBuildKind tells the items of the section apart. Build ids are
+// name:uuid, while reports, report groups, and projects are arns such as
+// arn:aws:codebuild:us-west-2:000000000000:report-group/name.
+func codeBuildKind(item string) string {
+	parts := strings.SplitN(item, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return codeBuildBuild
+	}
+
+	kind, _, _ := strings.Cut(parts[5], "/")
+	return kind
+}
+
+func byCodeBuildKind(items []string) map[string][]string {
+	kinds := make(map[string][]string)
+	for _, item := range items {
+		kind := codeBuildKind(item)
+		kinds[kind] = append(kinds[kind], item)
+	}
+	return kinds
+}
+
+// owner returns the project or report group carrying the tags of an item.
+func owner(item string) string {
+	switch codeBuildKind(item) {
+	case codeBuildBuild:
+		return project(item)
+	case codeBuildReport:
+		// report arns end in report/group:uuid.
+		return project(nameFromArn(item))
+	default:
+		return nameFromArn(item)
+	}
+}
+
+// project returns the project name of a build id, e.g. name:uuid.
+func project(id string) string {
+	p, _, _ := strings.Cut(id, ":")
+	return p
+}
+
+func (c CodeBuild) config(ctx context.Context) (aws.Config, error) {
+	return loadConfig(ctx, c.Region, c.Retry, c.Credentials)
 }
@@ -0,0 +1,30 @@
+package aws
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/stxkxs/ok-cli/logger"
+)
+
+// loadConfig loads the default aws configuration for a region, using the
+// given credentials instead of the default chain when they are set.
+func loadConfig(ctx context.Context, region string, retry int, credentials aws.CredentialsProvider) (aws.Config, error) {
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithRetryMaxAttempts(retry),
+		WithRateLimit(),
+	}
+
+	if credentials != nil {
+		opts = append(opts, config.WithCredentialsProvider(credentials))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("error loading default aws configurations")
+		return cfg, err
+	}
+
+	return cfg, nil
+}
@@ -31,7 +31,7 @@ type CostUsageReportingClient struct {
 }
 
 func NewCostUsageReportingClient() *CostUsageReportingClient {
-	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion("us-east-1"))
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion("us-east-1"), WithRateLimit())
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("error loading default foundation configurations")
 		return nil
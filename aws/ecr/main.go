@@ -6,6 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ecr"
 	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	"github.com/stxkxs/ok-cli/aws"
 	"github.com/stxkxs/ok-cli/logger"
 	"github.com/stxkxs/ok-cli/terminal"
 	"os"
@@ -119,15 +120,16 @@ type Private struct {
 }
 
 type Prep struct {
-	Account      string  `mapstructure:"account"`
-	Environment  string  `mapstructure:"environment"`
-	Version      string  `mapstructure:"version"`
-	Organization string  `mapstructure:"organization"`
-	Name         string  `mapstructure:"name"`
-	Alias        string  `mapstructure:"alias"`
-	Domain       string  `mapstructure:"domain"`
-	Private      Private `mapstructure:"private"`
-	Public       Public  `mapstructure:"public"`
+	Account      string                   `mapstructure:"account"`
+	Environment  string                   `mapstructure:"environment"`
+	Version      string                   `mapstructure:"version"`
+	Organization string                   `mapstructure:"organization"`
+	Name         string                   `mapstructure:"name"`
+	Alias        string                   `mapstructure:"alias"`
+	Domain       string                   `mapstructure:"domain"`
+	Private      Private                  `mapstructure:"private"`
+	Public       Public                   `mapstructure:"public"`
+	RateLimits   map[string]aws.RateLimit `mapstructure:"rateLimits"`
 }
 
 const repositoryPolicy = `
@@ -138,7 +140,7 @@ const repositoryPolicy = `
 `
 
 func NewPrivateEcrClient(region string) *PrivateClient {
-	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region), aws.WithRateLimit())
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("error loading default private ecr configurations")
 		return nil
@@ -150,7 +152,7 @@ func NewPrivateEcrClient(region string) *PrivateClient {
 }
 
 func NewPublicEcrClient(region string) *PublicClient {
-	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region), aws.WithRateLimit())
 	if err != nil {
 		logger.Logger.Error().Err(err).Msg("error loading default public ecr configurations")
 		return nil
@@ -0,0 +1,131 @@
+package aws
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Selector narrows resources by name. Globs support * and ? and, unlike
+// path.Match, * also matches / so log group paths can be matched whole.
+// A name is selected when it matches any include (or there are none) and
+// matches no exclude.
+type Selector struct {
+	Include      []string `mapstructure:"include"`
+	Exclude      []string `mapstructure:"exclude"`
+	IncludeRegex []string `mapstructure:"includeRegex"`
+	ExcludeRegex []string `mapstructure:"excludeRegex"`
+}
+
+type matcher struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func (s Selector) compile() (*matcher, error) {
+	m := &matcher{}
+
+	for _, g := range s.Include {
+		m.include = append(m.include, glob(g))
+	}
+
+	for _, g := range s.Exclude {
+		m.exclude = append(m.exclude, glob(g))
+	}
+
+	for _, r := range s.IncludeRegex {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include regex %q: %w", r, err)
+		}
+		m.include = append(m.include, re)
+	}
+
+	for _, r := range s.ExcludeRegex {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude regex %q: %w", r, err)
+		}
+		m.exclude = append(m.exclude, re)
+	}
+
+	return m, nil
+}
+
+func (m *matcher) matches(name string) bool {
+	for _, re := range m.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(m.include) == 0 {
+		return true
+	}
+
+	for _, re := range m.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func glob(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, `.*`)
+	quoted = strings.ReplaceAll(quoted, `\?`, `.`)
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// ParseAge parses a go duration with additional d (day) and w (week) units,
+// e.g. 14d, 2w, or 36h.
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	unit := s[len(s)-1]
+	if unit == 'd' || unit == 'w' {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: %w", s, err)
+		}
+
+		days := time.Duration(n) * 24 * time.Hour
+		if unit == 'w' {
+			days *= 7
+		}
+		return days, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+// cutoff resolves a point in time from either an absolute timestamp
+// (RFC3339 or yyyy-mm-dd) or an age relative to now. The zero time means
+// no cutoff was configured.
+func cutoff(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+
+	age, err := ParseAge(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid cutoff %q: expected a timestamp, date, or age", s)
+	}
+
+	return time.Now().Add(-age), nil
+}
@@ -0,0 +1,444 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/stxkxs/ok-cli/logger"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MarkTag holds the time after which tidy may delete a marked resource.
+	MarkTag = "stxkxs.io:tidy-after"
+
+	tagResourcesLimit = 20
+	notifyTimeout     = 10 * time.Second
+)
+
+// Grace defers deletion by Period. The first run that would delete a
+// resource tags it with MarkTag set to the end of the grace period and posts
+// the marked resources to Webhook, and only runs after that time delete it,
+// as long as it still carries the mark. Removing the mark during the grace
+// period spares the resource, tidy records what it marked and never marks a
+// resource again. Owner names the tag holding the owner of a resource,
+// included in notifications.
+// Deletion is immediate without a Period. Only resources tidy deletes whole
+// carry the mark: log groups, stacks, and codebuild projects and report
+// groups. Builds, reports, images, and log groups in enforce mode are reaped
+// without a grace period.
+type Grace struct {
+	Period  string `mapstructure:"period"`
+	Webhook string `mapstructure:"webhook"`
+	Owner   string `mapstructure:"owner"`
+}
+
+// Marked is a resource marked for deletion, as posted to the webhook.
+type Marked struct {
+	Arn   string `json:"arn"`
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+}
+
+type notification struct {
+	Text      string   `json:"text"`
+	Tag       string   `json:"tag"`
+	After     string   `json:"after"`
+	Resources []Marked `json:"resources"`
+}
+
+func (g Grace) enabled() bool {
+	return g.Period != ""
+}
+
+type pendingKey struct{}
+
+// WithPending returns a context in which the grace period leaves unmarked
+// resources as they are and reports each item to fn instead, so planning
+// changes nothing and only confirmed items get marked, by filtering them
+// again without fn. fn may be called from several goroutines.
+func WithPending(ctx context.Context, fn func(item string)) context.Context {
+	return context.WithValue(ctx, pendingKey{}, fn)
+}
+
+// or returns the tidy wide grace period g when the section sets none.
+func (g Grace) or(tidy Grace) Grace {
+	if g.enabled() {
+		return g
+	}
+	return tidy
+}
+
+func (g Grace) Validate() error {
+	if _, err := ParseAge(g.Period); err != nil {
+		return fmt.Errorf("invalid grace period: %w", err)
+	}
+
+	if g.Webhook != "" {
+		if u, err := url.Parse(g.Webhook); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid grace webhook %q", g.Webhook)
+		}
+	}
+
+	return nil
+}
+
+// after returns the mark of resources marked now.
+func (g Grace) after() (string, error) {
+	period, err := ParseAge(g.Period)
+	if err != nil {
+		return "", err
+	}
+
+	return time.Now().Add(period).UTC().Format(time.RFC3339), nil
+}
+
+// check reports whether a resource with the given tags may be deleted now.
+// marked is false when the resource carries no valid mark, reason tells why
+// a marked resource is spared.
+func (g Grace) check(tags map[string]string) (expired, marked bool, reason string) {
+	v, ok := tags[MarkTag]
+	if !ok {
+		return false, false, ""
+	}
+
+	after, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		logger.Logger.Warn().Str("tag", MarkTag).Str("value", v).Msg("ignoring invalid tidy mark")
+		return false, false, ""
+	}
+
+	if time.Now().Before(after) {
+		return false, true, "grace period until " + v
+	}
+
+	return true, true, ""
+}
+
+// filter applies the grace period to items whose owners, as derived by name,
+// are visible to the tagging api as resourceType. arn builds the arn of an
+// owner in account, under which marks are recorded.
+func (g Grace) filter(ctx context.Context, cfg aws.Config, account *callerAccount, resourceType string, items []string, name func(string) string, arn func(account, owner string) (string, error)) ([]string, error) {
+	if !g.enabled() || len(items) == 0 {
+		return items, nil
+	}
+
+	tags, err := cachedResourceTags(ctx, cfg, resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := g.after()
+	if err != nil {
+		return nil, err
+	}
+
+	var expired, due, unmarked []string
+	pending := make(map[string][]string)
+
+	for _, item := range items {
+		owner := name(item)
+
+		ok, marked, reason := g.check(tags.of(owner))
+		switch {
+		case ok:
+			if !slices.Contains(due, owner) {
+				due = append(due, owner)
+			}
+			expired = append(expired, item)
+		case marked:
+			logger.Logger.Debug().Str("item", item).Str("reason", reason).Msg("sparing marked resource")
+			skipped(ctx, item, reason)
+		default:
+			if _, ok := pending[owner]; !ok {
+				unmarked = append(unmarked, owner)
+			}
+			pending[owner] = append(pending[owner], item)
+		}
+	}
+
+	fn, planning := ctx.Value(pendingKey{}).(func(item string))
+	if len(unmarked) == 0 && (planning || len(due) == 0) {
+		return expired, nil
+	}
+
+	id, err := account.get()
+	if err != nil {
+		return nil, err
+	}
+
+	// expired marks are done with once their resources are deleted.
+	if !planning && len(due) > 0 {
+		var done []string
+		for _, owner := range due {
+			if a, err := arn(id, owner); err == nil {
+				done = append(done, a)
+			}
+		}
+
+		if err := forgetMarks(done); err != nil {
+			logger.Logger.Warn().Err(err).Msg("error forgetting expired tidy marks")
+		}
+	}
+
+	if len(unmarked) == 0 {
+		return expired, nil
+	}
+
+	arns := make(map[string]string, len(unmarked))
+	for _, owner := range unmarked {
+		a, err := arn(id, owner)
+		if err != nil {
+			for _, item := range pending[owner] {
+				skipped(ctx, item, "error marking for deletion: "+err.Error())
+			}
+			continue
+		}
+		arns[a] = owner
+	}
+
+	recorded, err := recordedMarks(slices.Collect(maps.Keys(arns)))
+	if err != nil {
+		return nil, err
+	}
+
+	for a, owner := range arns {
+		if !recorded[a] {
+			continue
+		}
+
+		logger.Logger.Info().Str("resource", owner).Str("tag", MarkTag).Msg("sparing resource whose tidy mark was removed")
+		for _, item := range pending[owner] {
+			skipped(ctx, item, "spared, "+MarkTag+" was removed")
+		}
+		delete(arns, a)
+	}
+
+	if planning {
+		for _, owner := range arns {
+			for _, item := range pending[owner] {
+				skipped(ctx, item, "to be marked for deletion after "+after)
+				fn(item)
+			}
+		}
+		return expired, nil
+	}
+
+	failed := tagResources(ctx, cfg, arns, after)
+
+	var marked []Marked
+	var record []string
+	for a, owner := range arns {
+		reason := "marked for deletion after " + after
+		if err, ok := failed[a]; ok {
+			reason = "error marking for deletion: " + err.Error()
+		} else {
+			marked = append(marked, Marked{Arn: a, Name: owner, Owner: tags.get(owner, g.Owner)})
+			record = append(record, a)
+			tags.set(owner, MarkTag, after)
+		}
+
+		for _, item := range pending[owner] {
+			skipped(ctx, item, reason)
+		}
+	}
+
+	if err := recordMarks(record, after); err != nil {
+		logger.Logger.Error().Err(err).Msg("error recording tidy marks, removing them will not spare the resources")
+	}
+
+	g.notify(ctx, after, marked)
+
+	return expired, nil
+}
+
+// resourceTags are the tags of the resources of one type by name, shared by
+// the pages of a stream.
+type resourceTags struct {
+	mu   *sync.Mutex
+	tags map[string]map[string]string
+}
+
+func (t resourceTags) of(name string) map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return maps.Clone(t.tags[name])
+}
+
+func (t resourceTags) get(name, key string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tags[name][key]
+}
+
+// set records a tag tidy added, so later pages see the mark.
+func (t resourceTags) set(name, key, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tags[name] == nil {
+		t.tags[name] = make(map[string]string)
+	}
+	t.tags[name][key] = value
+}
+
+// cachedResourceTags returns the tags of every resource of a tagging api
+// resource type, once per stream when ctx carries a tag cache.
+func cachedResourceTags(ctx context.Context, cfg aws.Config, resourceType string) (resourceTags, error) {
+	cache, ok := ctx.Value(tagCacheKey{}).(*tagCache)
+	if !ok {
+		tags, err := listResourceTags(ctx, cfg, resourceType)
+		return resourceTags{mu: &sync.Mutex{}, tags: tags}, err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	k := cfg.Region + "\x00" + resourceType
+	if tags, ok := cache.tags[k]; ok {
+		return resourceTags{mu: &cache.mu, tags: tags}, nil
+	}
+
+	tags, err := listResourceTags(ctx, cfg, resourceType)
+	if err != nil {
+		return resourceTags{}, err
+	}
+
+	cache.tags[k] = tags
+	return resourceTags{mu: &cache.mu, tags: tags}, nil
+}
+
+// listResourceTags returns the tags of every resource of a tagging api
+// resource type by name. Resources that never carried a tag are missing.
+func listResourceTags(ctx context.Context, cfg aws.Config, resourceType string) (map[string]map[string]string, error) {
+	api := resourcegroupstaggingapi.NewFromConfig(cfg)
+	input := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []string{resourceType},
+	}
+
+	tags := make(map[string]map[string]string)
+	for {
+		resp, err := api.GetResources(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("type", resourceType).Msg("error getting tagged resources")
+			return nil, err
+		}
+
+		for _, r := range resp.ResourceTagMappingList {
+			t := make(map[string]string, len(r.Tags))
+			for _, tag := range r.Tags {
+				t[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			tags[nameFromArn(aws.ToString(r.ResourceARN))] = t
+		}
+
+		if aws.ToString(resp.PaginationToken) == "" {
+			break
+		}
+		input.PaginationToken = resp.PaginationToken
+	}
+
+	return tags, nil
+}
+
+// tagResources marks the resources and returns those it could not tag.
+func tagResources(ctx context.Context, cfg aws.Config, arns map[string]string, after string) map[string]error {
+	api := resourcegroupstaggingapi.NewFromConfig(cfg)
+
+	list := make([]string, 0, len(arns))
+	for a := range arns {
+		list = append(list, a)
+	}
+
+	failed := make(map[string]error)
+	for _, batch := range batches(list, tagResourcesLimit) {
+		resp, err := api.TagResources(ctx, &resourcegroupstaggingapi.TagResourcesInput{
+			ResourceARNList: batch,
+			Tags:            map[string]string{MarkTag: after},
+		})
+		if err != nil {
+			logger.Logger.Error().Err(err).Strs("arns", batch).Msg("error marking resources for deletion")
+			for _, a := range batch {
+				failed[a] = err
+			}
+			continue
+		}
+
+		for a, f := range resp.FailedResourcesMap {
+			failed[a] = fmt.Errorf("%s: %s", f.ErrorCode, aws.ToString(f.ErrorMessage))
+		}
+
+		logger.Logger.Info().
+			Strs("arns", batch).
+			Str("after", after).
+			Msg("marked resources for deletion")
+	}
+
+	return failed
+}
+
+// notify posts the resources marked by a run to the webhook. A failed
+// notification is logged, the marks stand regardless.
+func (g Grace) notify(ctx context.Context, after string, marked []Marked) {
+	if g.Webhook == "" || len(marked) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(marked))
+	for _, m := range marked {
+		names = append(names, m.Name)
+	}
+
+	b, err := json.Marshal(notification{
+		Text:      fmt.Sprintf("tidy will delete %s after %s. remove the %s tag to keep them.", strings.Join(names, ", "), after, MarkTag),
+		Tag:       MarkTag,
+		After:     after,
+		Resources: marked,
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("error encoding tidy notification")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.Webhook, bytes.NewReader(b))
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("error creating tidy notification")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("error sending tidy notification")
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		logger.Logger.Error().Int("status", resp.StatusCode).Msg("tidy notification rejected")
+		return
+	}
+
+	logger.Logger.Info().
+		Int("resources", len(marked)).
+		Str("after", after).
+		Msg("notified owners of resources marked for deletion")
+}
+
+// resourceArn builds the arn of a resource in the region of cfg, in the aws
+// partition.
+func resourceArn(cfg aws.Config, account, service, resource string) string {
+	return fmt.Sprintf("arn:aws:%s:%s:%s:%s", service, cfg.Region, account, resource)
+}
@@ -19,7 +19,7 @@ type Iam interface {
 }
 
 func NewIamClient() *IamClient {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	cfg, err := config.LoadDefaultConfig(context.Background(), WithRateLimit())
 
 	if err != nil {
 		logger.Logger.Error().
@@ -33,7 +33,7 @@ func NewIamClient() *IamClient {
 
 func NewIamClientWithCredentials(c *sts.Credentials) *IamClient {
 	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithCredentialsProvider(
-		credentials.NewStaticCredentialsProvider(*c.AccessKeyId, *c.SecretAccessKey, *c.SessionToken)))
+		credentials.NewStaticCredentialsProvider(*c.AccessKeyId, *c.SecretAccessKey, *c.SessionToken)), WithRateLimit())
 
 	if err != nil {
 		logger.Logger.Error().
@@ -0,0 +1,832 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecrpublic"
+	ecrpublictypes "github.com/aws/aws-sdk-go-v2/service/ecrpublic/types"
+	"github.com/aws/smithy-go"
+	"github.com/stxkxs/ok-cli/logger"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	RegistryPrivate = "private"
+	RegistryPublic  = "public"
+
+	// publicRegion is the only region serving the ecr public api.
+	publicRegion = "us-east-1"
+
+	batchDeleteImageLimit = 100
+	batchGetImageLimit    = 100
+	latestTag             = "latest"
+	dateTag               = "20060102"
+)
+
+// indexMediaTypes are the manifest types of multi-arch images, whose
+// platform manifests are pushed untagged.
+var indexMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+// errNoManifests is returned by registries that cannot read image manifests.
+var errNoManifests = errors.New("ecr registry cannot read image manifests")
+
+// ECR prunes images from the repositories created by prep. Retention.OlderThan
+// expires images by their newest yyyymmdd tag, Retention.KeepLast keeps the
+// most recently pushed tagged images of each repository, and Untagged prunes
+// untagged manifests. Images tagged latest are always kept without counting
+// toward KeepLast, and untagged manifests of a kept multi-arch image are
+// kept with it. Public repositories are only served from us-east-1, so they
+// are pruned when the section runs there.
+type ECR struct {
+	Region      string                  `mapstructure:"region"`
+	Regions     []string                `mapstructure:"regions"`
+	BatchSize   int                     `mapstructure:"batchSize"`
+	Parallelism int                     `mapstructure:"parallelism"`
+	Retry       int                     `mapstructure:"retry"`
+	Prefix      []string                `mapstructure:"prefix"`
+	Registries  []string                `mapstructure:"registries"`
+	Untagged    bool                    `mapstructure:"untagged"`
+	Retention   Retention               `mapstructure:"retention"`
+	Tags        string                  `mapstructure:"tags"`
+	Protect     Protect                 `mapstructure:"protect"`
+	Credentials aws.CredentialsProvider `mapstructure:"-" json:"-"`
+}
+
+type repository struct {
+	name string
+	arn  string
+}
+
+type image struct {
+	repository repository
+	digest     string
+	tags       []string
+	pushed     time.Time
+	size       int64
+	index      bool
+}
+
+// registry hides the differences between the private and public ecr apis.
+type registry interface {
+	repositories(ctx context.Context) ([]repository, error)
+	tags(ctx context.Context, arn string) (map[string]string, error)
+	images(ctx context.Context, repository string) ([]image, error)
+	children(ctx context.Context, repository string, indexes []string) (map[string]bool, error)
+	delete(ctx context.Context, repository string, digests []string) (map[string]error, error)
+	exists(ctx context.Context, repository, digest string) (bool, error)
+}
+
+type privateRegistry struct {
+	api *ecr.Client
+}
+
+type publicRegistry struct {
+	api *ecrpublic.Client
+}
+
+// ecrReaper remembers the images it listed so Filter can apply the
+// retention rules without describing every repository again.
+type ecrReaper struct {
+	c      ECR
+	images *listed[image]
+}
+
+func DestroyImages(c ECR) error {
+	_, err := Reap(context.Background(), c.reaper())
+	return err
+}
+
+// ListImages returns the expired images as repository@digest, prefixed with
+// public: for images in a public repository.
+func ListImages(c ECR) ([]string, error) {
+	return Candidates(context.Background(), c.reaper())
+}
+
+func DeleteImages(c ECR, images []string) error {
+	return c.reaper().Delete(context.Background(), images)
+}
+
+func (c *ECR) Scope() (string, []string) {
+	return c.Region, c.Regions
+}
+
+// Defaults ignores the grace period. ECR prunes images, which cannot carry
+// the tidy mark, and never deletes repositories, so repositories are left
+// out of the grace period as well.
+func (c *ECR) Defaults(tags string, protect Protect, _ Grace) {
+	defaults(&c.Tags, &c.Protect, tags, protect)
+}
+
+func (c *ECR) Validate() error {
+	return validate(c.Tags, &c.Protect)
+}
+
+func (c *ECR) Reaper(region string, credentials aws.CredentialsProvider) Reaper {
+	e := *c
+	e.Region = region
+	e.Credentials = credentials
+	return e.reaper()
+}
+
+func (c ECR) reaper() ecrReaper {
+	return ecrReaper{c: c, images: newListed[image]()}
+}
+
+// List returns every image of the repositories matching the prefix.
+func (r ecrReaper) List(ctx context.Context) ([]string, error) {
+	var ids []string
+
+	err := r.Pages(ctx, func(page []string) error {
+		ids = append(ids, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Pages lists the images one repository at a time, since retention applies
+// per repository.
+func (r ecrReaper) Pages(ctx context.Context, page func(ids []string) error) error {
+	cfg, err := r.c.config(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range r.c.registries() {
+		reg := r.c.registry(cfg, name)
+		if reg == nil {
+			continue
+		}
+
+		repos, err := reg.repositories(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, repo := range repos {
+			if !matchesPrefix(repo.name, r.c.Prefix) {
+				continue
+			}
+
+			images, err := reg.images(ctx, repo.name)
+			if err != nil {
+				return err
+			}
+
+			ids := make([]string, 0, len(images))
+			for _, i := range images {
+				id := imageId(name, repo.name, i.digest)
+				i.repository = repo
+				r.images.set(id, i)
+				ids = append(ids, id)
+			}
+
+			if err := page(ids); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Filter drops images of repositories not selected by tags or protected, and
+// images retained by the retention rules.
+func (r ecrReaper) Filter(ctx context.Context, ids []string) ([]string, error) {
+	cfg, err := r.c.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var selector TagSelector
+	if r.c.Tags != "" {
+		selector, err = ParseTagSelector(r.c.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type key struct{ registry, repository string }
+
+	var keys []key
+	grouped := make(map[key][]image)
+	for _, id := range ids {
+		name, repo, _ := parseImageId(id)
+		k := key{name, repo}
+
+		i, ok := r.images.get(id)
+		if !ok {
+			return nil, fmt.Errorf("ecr image %s was not listed", id)
+		}
+
+		if _, ok := grouped[k]; !ok {
+			keys = append(keys, k)
+		}
+		grouped[k] = append(grouped[k], i)
+	}
+
+	var expired []string
+	for _, k := range keys {
+		reg := r.c.registry(cfg, k.registry)
+		if reg == nil {
+			continue
+		}
+
+		reason, err := r.c.selected(ctx, reg, k.registry, grouped[k][0].repository, selector)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			for _, i := range grouped[k] {
+				skipped(ctx, imageId(k.registry, k.repository, i.digest), reason)
+			}
+			continue
+		}
+
+		digests, err := r.c.expire(ctx, reg, k.registry, k.repository, grouped[k])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, digest := range digests {
+			expired = append(expired, imageId(k.registry, k.repository, digest))
+		}
+	}
+
+	return expired, nil
+}
+
+func (r ecrReaper) Delete(ctx context.Context, images []string) error {
+	c := r.c
+
+	if len(images) == 0 {
+		logger.Logger.Warn().Str("region", c.Region).Msg("no images found in region")
+		return nil
+	}
+
+	cfg, err := c.config(ctx)
+	if err != nil {
+		return err
+	}
+
+	type key struct{ registry, repository string }
+
+	var keys []key
+	grouped := make(map[key][]string)
+	for _, id := range images {
+		name, repo, _ := parseImageId(id)
+		k := key{name, repo}
+		if _, ok := grouped[k]; !ok {
+			keys = append(keys, k)
+		}
+		grouped[k] = append(grouped[k], id)
+	}
+
+	batchSize := c.BatchSize
+	if batchSize <= 0 || batchSize > batchDeleteImageLimit {
+		batchSize = batchDeleteImageLimit
+	}
+
+	// batches never span repositories since the delete apis take one
+	// repository at a time.
+	var b [][]string
+	for _, k := range keys {
+		b = append(b, batches(grouped[k], batchSize)...)
+	}
+
+	registries := make(map[string]registry)
+	for _, k := range keys {
+		if _, ok := registries[k.registry]; !ok {
+			registries[k.registry] = c.registry(cfg, k.registry)
+		}
+	}
+
+	failures := deleteBatches(ctx, "image", c.Region, b, c.Parallelism, func(ctx context.Context, batch []string) (Failures, error) {
+		name, repo, _ := parseImageId(batch[0])
+
+		reg := registries[name]
+		if reg == nil {
+			return nil, fmt.Errorf("ecr %s registry is not available in %s", name, c.Region)
+		}
+
+		digests := make([]string, 0, len(batch))
+		for _, id := range batch {
+			_, _, digest := parseImageId(id)
+			digests = append(digests, digest)
+		}
+
+		logger.Logger.Debug().Str("repository", repo).Strs("digests", digests).Msg("deleting images")
+
+		notDeleted, err := reg.delete(ctx, repo, digests)
+		if err != nil {
+			return nil, err
+		}
+
+		failed := make(Failures)
+		for digest, err := range notDeleted {
+			failed.fail(err, imageId(name, repo, digest))
+		}
+
+		logger.Logger.Info().
+			Str("registry", name).
+			Str("repository", repo).
+			Strs("digests", digests).
+			Msg("deleted images")
+
+		return failed, nil
+	})
+
+	return failures.err()
+}
+
+func (r ecrReaper) Size(id string) int64 {
+	i, _ := r.images.get(id)
+	return i.size
+}
+
+func (r ecrReaper) Forget(ids []string) {
+	r.images.forget(ids)
+}
+
+// Gone reports the images that no longer exist, or whose repository is gone.
+func (r ecrReaper) Gone(ctx context.Context, ids []string) ([]string, error) {
+	cfg, err := r.c.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var gone []string
+	for _, id := range ids {
+		name, repo, digest := parseImageId(id)
+
+		reg := r.c.registry(cfg, name)
+		if reg == nil {
+			continue
+		}
+
+		ok, err := reg.exists(ctx, repo, digest)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			gone = append(gone, id)
+		}
+	}
+
+	return gone, nil
+}
+
+func (r ecrReaper) Describe(id string) string {
+	name, repo, digest := parseImageId(id)
+	return fmt.Sprintf("ecr %s image %s in repository %s", name, digest, repo)
+}
+
+// selected applies the tag selector and protect rules to a repository and
+// returns why the repository is skipped, or nothing when it is selected.
+func (c ECR) selected(ctx context.Context, r registry, name string, repo repository, selector TagSelector) (string, error) {
+	var tags map[string]string
+	if selector != nil || len(c.Protect.Tags) > 0 {
+		var err error
+		tags, err = r.tags(ctx, repo.arn)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if selector != nil && !selector.Matches(tags) {
+		logger.Logger.Debug().Str("repository", repo.name).Str("tags", c.Tags).Msg("skipping resource not selected by tags")
+		return "not selected by tags " + c.Tags, nil
+	}
+
+	service := "ecr"
+	if name == RegistryPublic {
+		service = "ecr-public"
+	}
+
+	if rule, ok := c.Protect.protects(c.Region, arnAccount(repo.arn), service, repo.name, tags); ok {
+		logger.Logger.Info().
+			Str("resource", repo.name).
+			Str("rule", rule).
+			Msg("skipping protected resource")
+		return rule, nil
+	}
+
+	return "", nil
+}
+
+// expire applies the retention rules to the images of one repository and
+// returns the digests to delete.
+func (c ECR) expire(ctx context.Context, reg registry, name, repository string, images []image) ([]string, error) {
+	older, err := c.Retention.olderThan()
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(images, func(a, b image) int { return b.pushed.Compare(a.pushed) })
+
+	var expired, untagged, indexes []string
+	kept := 0
+
+	for _, i := range images {
+		if len(i.tags) == 0 {
+			if c.Untagged {
+				untagged = append(untagged, i.digest)
+			} else {
+				skipped(ctx, imageId(name, repository, i.digest), "untagged pruning disabled")
+			}
+			continue
+		}
+
+		var reason string
+		switch {
+		case slices.Contains(i.tags, latestTag):
+			reason = "latest tag"
+		case kept < c.Retention.KeepLast:
+			kept++
+			reason = fmt.Sprintf("retention keepLast %d", c.Retention.KeepLast)
+		case older.IsZero() && c.Retention.KeepLast <= 0:
+			reason = "no retention rule for tagged images"
+		case !older.IsZero():
+			if d, ok := dated(i.tags); !ok || d.After(older) {
+				reason = "retention olderThan " + c.Retention.OlderThan
+			}
+		}
+
+		if reason == "" {
+			expired = append(expired, i.digest)
+			continue
+		}
+
+		logger.Logger.Debug().
+			Str("repository", repository).
+			Str("digest", i.digest).
+			Str("reason", reason).
+			Msg("retaining image")
+		skipped(ctx, imageId(name, repository, i.digest), reason)
+
+		if i.index {
+			indexes = append(indexes, i.digest)
+		}
+	}
+
+	if len(untagged) == 0 {
+		return expired, nil
+	}
+
+	// untagged platform manifests of a kept multi-arch image are part of it.
+	var referenced map[string]bool
+	unreadable := false
+	if len(indexes) > 0 {
+		referenced, err = reg.children(ctx, repository, indexes)
+		if unreadable = errors.Is(err, errNoManifests); err != nil && !unreadable {
+			return nil, err
+		}
+	}
+
+	for _, digest := range untagged {
+		switch {
+		case unreadable:
+			skipped(ctx, imageId(name, repository, digest), "repository has kept multi-arch images")
+		case referenced[digest]:
+			skipped(ctx, imageId(name, repository, digest), "referenced by a kept multi-arch image")
+		default:
+			expired = append(expired, digest)
+		}
+	}
+
+	return expired, nil
+}
+
+// index reports whether a manifest media type is a multi-arch image index.
+func index(mediaType *string) bool {
+	return slices.Contains(indexMediaTypes, aws.ToString(mediaType))
+}
+
+// dated returns the newest yyyymmdd tag of an image, as pushed by prep.
+func dated(tags []string) (time.Time, bool) {
+	var newest time.Time
+	for _, t := range tags {
+		if len(t) != len(dateTag) {
+			continue
+		}
+
+		d, err := time.Parse(dateTag, t)
+		if err == nil && d.After(newest) {
+			newest = d
+		}
+	}
+
+	return newest, !newest.IsZero()
+}
+
+// registries returns the configured registry names lowercased, the form
+// image ids carry.
+func (c ECR) registries() []string {
+	if len(c.Registries) == 0 {
+		return []string{RegistryPrivate}
+	}
+
+	names := make([]string, 0, len(c.Registries))
+	for _, name := range c.Registries {
+		names = append(names, strings.ToLower(name))
+	}
+	return names
+}
+
+func (c ECR) registry(cfg aws.Config, name string) registry {
+	switch name {
+	case RegistryPrivate:
+		return privateRegistry{api: ecr.NewFromConfig(cfg)}
+	case RegistryPublic:
+		if c.Region != publicRegion {
+			logger.Logger.Debug().Str("region", c.Region).Msg("skipping ecr public registry outside us-east-1")
+			return nil
+		}
+		return publicRegistry{api: ecrpublic.NewFromConfig(cfg)}
+	default:
+		logger.Logger.Warn().Str("registry", name).Msg("skipping unknown ecr registry")
+		return nil
+	}
+}
+
+func imageId(registry, repository, digest string) string {
+	id := repository + "@" + digest
+	if registry == RegistryPublic {
+		return RegistryPublic + ":" + id
+	}
+	return id
+}
+
+func parseImageId(id string) (registry, repository, digest string) {
+	registry = RegistryPrivate
+	if rest, ok := strings.CutPrefix(id, RegistryPublic+":"); ok {
+		registry, id = RegistryPublic, rest
+	}
+
+	repository, digest, _ = strings.Cut(id, "@")
+	return registry, repository, digest
+}
+
+func (r privateRegistry) repositories(ctx context.Context) ([]repository, error) {
+	var repos []repository
+	input := &ecr.DescribeRepositoriesInput{}
+
+	for {
+		resp, err := r.api.DescribeRepositories(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("error retrieving private ecr repositories")
+			return nil, err
+		}
+
+		for _, repo := range resp.Repositories {
+			repos = append(repos, repository{name: aws.ToString(repo.RepositoryName), arn: aws.ToString(repo.RepositoryArn)})
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return repos, nil
+}
+
+func (r privateRegistry) tags(ctx context.Context, arn string) (map[string]string, error) {
+	resp, err := r.api.ListTagsForResource(ctx, &ecr.ListTagsForResourceInput{ResourceArn: &arn})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("repository", arn).Msg("error listing private ecr repository tags")
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(resp.Tags))
+	for _, t := range resp.Tags {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+
+	return tags, nil
+}
+
+func (r privateRegistry) images(ctx context.Context, repository string) ([]image, error) {
+	var images []image
+	input := &ecr.DescribeImagesInput{RepositoryName: &repository}
+
+	for {
+		resp, err := r.api.DescribeImages(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("repository", repository).Msg("error describing private ecr images")
+			return nil, err
+		}
+
+		for _, i := range resp.ImageDetails {
+			images = append(images, image{digest: aws.ToString(i.ImageDigest), tags: i.ImageTags, pushed: aws.ToTime(i.ImagePushedAt), size: aws.ToInt64(i.ImageSizeInBytes), index: index(i.ImageManifestMediaType)})
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return images, nil
+}
+
+func (r privateRegistry) exists(ctx context.Context, repository, digest string) (bool, error) {
+	_, err := r.api.DescribeImages(ctx, &ecr.DescribeImagesInput{
+		RepositoryName: &repository,
+		ImageIds:       []ecrtypes.ImageIdentifier{{ImageDigest: aws.String(digest)}},
+	})
+	if gone(err) {
+		return false, nil
+	}
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("repository", repository).Str("digest", digest).Msg("error describing private ecr image")
+		return false, err
+	}
+	return true, nil
+}
+
+// children returns the digests of the platform manifests referenced by the
+// given image indexes.
+func (r privateRegistry) children(ctx context.Context, repository string, indexes []string) (map[string]bool, error) {
+	children := make(map[string]bool)
+
+	for _, batch := range batches(indexes, batchGetImageLimit) {
+		ids := make([]ecrtypes.ImageIdentifier, len(batch))
+		for i, d := range batch {
+			ids[i] = ecrtypes.ImageIdentifier{ImageDigest: aws.String(d)}
+		}
+
+		resp, err := r.api.BatchGetImage(ctx, &ecr.BatchGetImageInput{RepositoryName: &repository, ImageIds: ids, AcceptedMediaTypes: indexMediaTypes})
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("repository", repository).Msg("error getting private ecr image indexes")
+			return nil, err
+		}
+
+		if len(resp.Failures) > 0 {
+			f := resp.Failures[0]
+			return nil, &smithy.GenericAPIError{Code: string(f.FailureCode), Message: aws.ToString(f.FailureReason)}
+		}
+
+		for _, i := range resp.Images {
+			var manifest struct {
+				Manifests []struct {
+					Digest string `json:"digest"`
+				} `json:"manifests"`
+			}
+			if err := json.Unmarshal([]byte(aws.ToString(i.ImageManifest)), &manifest); err != nil {
+				return nil, fmt.Errorf("ecr image index %s in repository %s: %w", aws.ToString(i.ImageId.ImageDigest), repository, err)
+			}
+
+			for _, m := range manifest.Manifests {
+				children[m.Digest] = true
+			}
+		}
+	}
+
+	return children, nil
+}
+
+func (r privateRegistry) delete(ctx context.Context, repository string, digests []string) (map[string]error, error) {
+	ids := make([]ecrtypes.ImageIdentifier, len(digests))
+	for i, d := range digests {
+		ids[i] = ecrtypes.ImageIdentifier{ImageDigest: aws.String(d)}
+	}
+
+	resp, err := r.api.BatchDeleteImage(ctx, &ecr.BatchDeleteImageInput{RepositoryName: &repository, ImageIds: ids})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("repository", repository).Msg("error deleting private ecr images")
+		return nil, err
+	}
+
+	failed := make(map[string]error)
+	for _, f := range resp.Failures {
+		if f.ImageId != nil {
+			failed[aws.ToString(f.ImageId.ImageDigest)] = &smithy.GenericAPIError{Code: string(f.FailureCode), Message: aws.ToString(f.FailureReason)}
+		}
+	}
+
+	return failed, nil
+}
+
+func (r publicRegistry) repositories(ctx context.Context) ([]repository, error) {
+	var repos []repository
+	input := &ecrpublic.DescribeRepositoriesInput{}
+
+	for {
+		resp, err := r.api.DescribeRepositories(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("error retrieving public ecr repositories")
+			return nil, err
+		}
+
+		for _, repo := range resp.Repositories {
+			repos = append(repos, repository{name: aws.ToString(repo.RepositoryName), arn: aws.ToString(repo.RepositoryArn)})
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return repos, nil
+}
+
+func (r publicRegistry) tags(ctx context.Context, arn string) (map[string]string, error) {
+	resp, err := r.api.ListTagsForResource(ctx, &ecrpublic.ListTagsForResourceInput{ResourceArn: &arn})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("repository", arn).Msg("error listing public ecr repository tags")
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(resp.Tags))
+	for _, t := range resp.Tags {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+
+	return tags, nil
+}
+
+func (r publicRegistry) images(ctx context.Context, repository string) ([]image, error) {
+	var images []image
+	input := &ecrpublic.DescribeImagesInput{RepositoryName: &repository}
+
+	for {
+		resp, err := r.api.DescribeImages(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("repository", repository).Msg("error describing public ecr images")
+			return nil, err
+		}
+
+		for _, i := range resp.ImageDetails {
+			images = append(images, image{digest: aws.ToString(i.ImageDigest), tags: i.ImageTags, pushed: aws.ToTime(i.ImagePushedAt), size: aws.ToInt64(i.ImageSizeInBytes), index: index(i.ImageManifestMediaType)})
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return images, nil
+}
+
+func (r publicRegistry) exists(ctx context.Context, repository, digest string) (bool, error) {
+	_, err := r.api.DescribeImages(ctx, &ecrpublic.DescribeImagesInput{
+		RepositoryName: &repository,
+		ImageIds:       []ecrpublictypes.ImageIdentifier{{ImageDigest: aws.String(digest)}},
+	})
+	if gone(err) {
+		return false, nil
+	}
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("repository", repository).Str("digest", digest).Msg("error describing public ecr image")
+		return false, err
+	}
+	return true, nil
+}
+
+// children is not supported by the ecr public api, which cannot read
+// manifests.
+func (r publicRegistry) children(context.Context, string, []string) (map[string]bool, error) {
+	return nil, errNoManifests
+}
+
+func (r publicRegistry) delete(ctx context.Context, repository string, digests []string) (map[string]error, error) {
+	ids := make([]ecrpublictypes.ImageIdentifier, len(digests))
+	for i, d := range digests {
+		ids[i] = ecrpublictypes.ImageIdentifier{ImageDigest: aws.String(d)}
+	}
+
+	resp, err := r.api.BatchDeleteImage(ctx, &ecrpublic.BatchDeleteImageInput{RepositoryName: &repository, ImageIds: ids})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("repository", repository).Msg("error deleting public ecr images")
+		return nil, err
+	}
+
+	failed := make(map[string]error)
+	for _, f := range resp.Failures {
+		if f.ImageId != nil {
+			failed[aws.ToString(f.ImageId.ImageDigest)] = &smithy.GenericAPIError{Code: string(f.FailureCode), Message: aws.ToString(f.FailureReason)}
+		}
+	}
+
+	return failed, nil
+}
+
+func (c ECR) config(ctx context.Context) (aws.Config, error) {
+	return loadConfig(ctx, c.Region, c.Retry, c.Credentials)
+}
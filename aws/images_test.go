@@ -0,0 +1,98 @@
+package aws
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// stubRegistry reads image indexes from a map, or cannot read them at all.
+type stubRegistry struct {
+	registry
+	indexes map[string][]string
+}
+
+func (s stubRegistry) children(_ context.Context, _ string, indexes []string) (map[string]bool, error) {
+	if s.indexes == nil {
+		return nil, errNoManifests
+	}
+
+	children := make(map[string]bool)
+	for _, digest := range indexes {
+		for _, child := range s.indexes[digest] {
+			children[child] = true
+		}
+	}
+	return children, nil
+}
+
+func TestECRExpire(t *testing.T) {
+	now := time.Now()
+	images := func() []image {
+		return []image{
+			{digest: "latest", tags: []string{"latest"}, pushed: now},
+			{digest: "new", tags: []string{"v2", "20200102"}, pushed: now.Add(-time.Hour), index: true},
+			{digest: "old", tags: []string{"v1", "20200101"}, pushed: now.Add(-2 * time.Hour), index: true},
+			{digest: "new-amd64", pushed: now.Add(-time.Hour)},
+			{digest: "old-amd64", pushed: now.Add(-2 * time.Hour)},
+			{digest: "orphan", pushed: now.Add(-3 * time.Hour)},
+		}
+	}
+	indexes := map[string][]string{"new": {"new-amd64"}, "old": {"old-amd64"}}
+
+	for _, tc := range []struct {
+		name string
+		c    ECR
+		reg  stubRegistry
+		want []string
+	}{
+		{
+			name: "latest does not count toward keepLast",
+			c:    ECR{Retention: Retention{KeepLast: 1}},
+			reg:  stubRegistry{indexes: indexes},
+			want: []string{"old"},
+		},
+		{
+			name: "untagged children of kept indexes are kept",
+			c:    ECR{Untagged: true, Retention: Retention{KeepLast: 1}},
+			reg:  stubRegistry{indexes: indexes},
+			want: []string{"old", "old-amd64", "orphan"},
+		},
+		{
+			name: "unreadable indexes keep every untagged image",
+			c:    ECR{Untagged: true, Retention: Retention{KeepLast: 1}},
+			reg:  stubRegistry{},
+			want: []string{"old"},
+		},
+		{
+			name: "expired indexes release their children",
+			c:    ECR{Untagged: true, Retention: Retention{OlderThan: "1d"}},
+			reg:  stubRegistry{},
+			want: []string{"new", "new-amd64", "old", "old-amd64", "orphan"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.c.expire(context.Background(), tc.reg, RegistryPrivate, "repo", images())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("expired %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestECRRegistries(t *testing.T) {
+	c := ECR{Registries: []string{"Private", "PUBLIC"}}
+	if got := c.registries(); !slices.Equal(got, []string{RegistryPrivate, RegistryPublic}) {
+		t.Fatalf("registries %v, want lowercased", got)
+	}
+
+	if id := imageId(c.registries()[1], "repo", "sha256:1"); id != "public:repo@sha256:1" {
+		t.Errorf("image id %s", id)
+	}
+}
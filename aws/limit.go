@@ -0,0 +1,182 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/stxkxs/ok-cli/logger"
+	"golang.org/x/time/rate"
+	"strings"
+	"sync"
+)
+
+// defaultRateLimit applies to services without their own entry in the
+// rateLimits table, and to every service when the table is not set.
+const defaultRateLimit = "default"
+
+// minRate is the floor adaptive backoff never goes below, in requests per
+// second.
+const minRate = 0.5
+
+// RateLimit caps the api calls of one service. Rate is the steady number of
+// requests per second and Burst how many requests may start at once.
+type RateLimit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// limits are keyed by service, the sdk service id in lower case without
+// spaces, e.g. codebuild, cloudwatchlogs, cloudformation, ecr, or ecrpublic.
+var limits = struct {
+	sync.Mutex
+	config   map[string]RateLimit
+	limiters map[string]*limiter
+}{
+	config:   map[string]RateLimit{defaultRateLimit: {Rate: 10, Burst: 10}},
+	limiters: make(map[string]*limiter),
+}
+
+// SetRateLimits configures the shared limiters, merging limits into the
+// defaults. It should run before the first api call.
+func SetRateLimits(l map[string]RateLimit) {
+	limits.Lock()
+	defer limits.Unlock()
+
+	for service, limit := range l {
+		limits.config[normalizeService(service)] = limit
+	}
+	limits.limiters = make(map[string]*limiter)
+}
+
+// Throttles returns how often each service throttled a request so far.
+func Throttles() map[string]int64 {
+	limits.Lock()
+	defer limits.Unlock()
+
+	t := make(map[string]int64)
+	for service, l := range limits.limiters {
+		if n := l.throttled(); n > 0 {
+			t[service] = n
+		}
+	}
+	return t
+}
+
+// WithRateLimit routes every request made with the loaded config through the
+// limiter of its service, once per attempt, so retries are paced as well.
+func WithRateLimit() func(*config.LoadOptions) error {
+	return config.WithAPIOptions([]func(*middleware.Stack) error{
+		func(stack *middleware.Stack) error {
+			return stack.Finalize.Add(rateLimitMiddleware{}, middleware.After)
+		},
+	})
+}
+
+type rateLimitMiddleware struct{}
+
+func (rateLimitMiddleware) ID() string {
+	return "OkRateLimit"
+}
+
+func (rateLimitMiddleware) HandleFinalize(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	l := limiterFor(awsmiddleware.GetServiceID(ctx))
+	if err := l.wait(ctx); err != nil {
+		return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+	}
+
+	out, metadata, err := next.HandleFinalize(ctx, in)
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if _, ok := retry.DefaultThrottleErrorCodes[apiErr.ErrorCode()]; ok {
+			l.throttle(awsmiddleware.GetOperationName(ctx))
+			return out, metadata, err
+		}
+	}
+
+	if err == nil {
+		l.recover()
+	}
+
+	return out, metadata, err
+}
+
+func limiterFor(serviceId string) *limiter {
+	service := normalizeService(serviceId)
+
+	limits.Lock()
+	defer limits.Unlock()
+
+	if l, ok := limits.limiters[service]; ok {
+		return l
+	}
+
+	c, ok := limits.config[service]
+	if !ok {
+		c = limits.config[defaultRateLimit]
+	}
+	if c.Rate <= 0 {
+		c.Rate = 10
+	}
+	if c.Burst <= 0 {
+		c.Burst = max(1, int(c.Rate))
+	}
+
+	l := &limiter{service: service, max: rate.Limit(c.Rate), limiter: rate.NewLimiter(rate.Limit(c.Rate), c.Burst)}
+	limits.limiters[service] = l
+	return l
+}
+
+func normalizeService(serviceId string) string {
+	return strings.ToLower(strings.ReplaceAll(serviceId, " ", ""))
+}
+
+// limiter halves its rate on every throttled request and climbs back towards
+// the configured rate by a twentieth of it on every success.
+type limiter struct {
+	service string
+	max     rate.Limit
+	limiter *rate.Limiter
+
+	mu        sync.Mutex
+	throttles int64
+}
+
+func (l *limiter) wait(ctx context.Context) error {
+	return l.limiter.Wait(ctx)
+}
+
+func (l *limiter) throttle(operation string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.throttles++
+	limit := max(l.limiter.Limit()/2, minRate)
+	l.limiter.SetLimit(limit)
+
+	logger.Logger.Warn().
+		Str("service", l.service).
+		Str("operation", operation).
+		Float64("rate", float64(limit)).
+		Int64("throttles", l.throttles).
+		Msg("throttled by aws, backing off")
+}
+
+func (l *limiter) recover() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit := l.limiter.Limit(); limit < l.max {
+		l.limiter.SetLimit(min(limit+l.max/20, l.max))
+	}
+}
+
+func (l *limiter) throttled() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.throttles
+}
@@ -0,0 +1,112 @@
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// marksFile records, next to the tidy journal, the arns tidy marked and the
+// mark each got, so a run tells a resource whose mark was removed during its
+// grace period, which is spared, from one never marked.
+var marksFile = "~/.ok/state/tidy-marks.json"
+
+// marksMu serializes updates of marksFile within the process.
+var marksMu sync.Mutex
+
+// recordedMarks returns which of the given arns tidy marked before.
+func recordedMarks(arns []string) (map[string]bool, error) {
+	marksMu.Lock()
+	defer marksMu.Unlock()
+
+	marks, err := readMarks()
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := make(map[string]bool)
+	for _, arn := range arns {
+		if _, ok := marks[arn]; ok {
+			recorded[arn] = true
+		}
+	}
+
+	return recorded, nil
+}
+
+// recordMarks remembers that the arns were marked with after.
+func recordMarks(arns []string, after string) error {
+	return updateMarks(func(marks map[string]string) {
+		for _, arn := range arns {
+			marks[arn] = after
+		}
+	})
+}
+
+// forgetMarks drops arns whose mark expired, so a resource created again
+// under the same arn starts over.
+func forgetMarks(arns []string) error {
+	return updateMarks(func(marks map[string]string) {
+		for _, arn := range arns {
+			delete(marks, arn)
+		}
+	})
+}
+
+func updateMarks(update func(marks map[string]string)) error {
+	marksMu.Lock()
+	defer marksMu.Unlock()
+
+	marks, err := readMarks()
+	if err != nil {
+		return err
+	}
+
+	update(marks)
+
+	path, err := expandHome(marksFile)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(marks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// replace the file whole, so a killed run never leaves it torn.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func readMarks() (map[string]string, error) {
+	path, err := expandHome(marksFile)
+	if err != nil {
+		return nil, err
+	}
+
+	marks := make(map[string]string)
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return marks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &marks); err != nil {
+		return nil, err
+	}
+
+	return marks, nil
+}
@@ -0,0 +1,36 @@
+package aws
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMarks(t *testing.T) {
+	marksFile = filepath.Join(t.TempDir(), "state", "tidy-marks.json")
+
+	const (
+		kept    = "arn:aws:logs:us-west-2:000000000000:log-group:/tidy/kept"
+		expired = "arn:aws:logs:us-west-2:000000000000:log-group:/tidy/expired"
+		never   = "arn:aws:logs:us-west-2:000000000000:log-group:/tidy/never"
+	)
+
+	recorded, err := recordedMarks([]string{kept})
+	if err != nil || len(recorded) != 0 {
+		t.Fatalf("recorded %v, %v before any mark", recorded, err)
+	}
+
+	if err := recordMarks([]string{kept, expired}, "2026-01-01T00:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	if err := forgetMarks([]string{expired}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err = recordedMarks([]string{kept, expired, never})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !recorded[kept] || recorded[expired] || recorded[never] {
+		t.Errorf("recorded %v, want only %s", recorded, kept)
+	}
+}
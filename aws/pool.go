@@ -0,0 +1,198 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/smithy-go"
+	"github.com/stxkxs/ok-cli/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeleteParallelism = 4
+	progressInterval         = 10 * time.Second
+)
+
+// batches splits items into batches of at most size items.
+func batches(items []string, size int) [][]string {
+	size = max(size, 1)
+
+	var b [][]string
+	for i := 0; i < len(items); i += size {
+		b = append(b, items[i:min(i+size, len(items))])
+	}
+	return b
+}
+
+func count(b [][]string) int {
+	n := 0
+	for _, batch := range b {
+		n += len(batch)
+	}
+	return n
+}
+
+// deleteBatches runs del for every batch with up to parallelism batches in
+// flight. del returns the items of its batch that failed, or an error that
+// fails the whole batch. Once ctx is cancelled no further batches start, the
+// batches in flight finish, and the items never started fail with the
+// cancellation. Items that no longer exist count as deleted. Progress is
+// logged on its own unless ctx carries the progress of a stream.
+func deleteBatches(ctx context.Context, resource, region string, b [][]string, parallelism int, del func(ctx context.Context, batch []string) (Failures, error)) Failures {
+	if parallelism <= 0 {
+		parallelism = defaultDeleteParallelism
+	}
+
+	p, streaming := ctx.Value(progressKey{}).(*progress)
+	if !streaming {
+		p = &progress{resource: resource, region: region, total: count(b), logged: time.Now()}
+	}
+
+	failures := make(Failures)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+
+	for i, batch := range b {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+
+		if err := ctx.Err(); err != nil {
+			mu.Lock()
+			for _, rest := range b[i:] {
+				failures.fail(err, rest...)
+			}
+			mu.Unlock()
+
+			logger.Logger.Warn().
+				Str("resource", resource).
+				Str("region", region).
+				Int("remaining", count(b[i:])).
+				Msg("deletion cancelled. waiting for batches in flight.")
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			failed, err := del(ctx, batch)
+			if gone(err) {
+				logger.Logger.Debug().Str("resource", resource).Strs("items", batch).Msg("already deleted")
+				err = nil
+			}
+			for item, err := range failed {
+				if gone(err) {
+					logger.Logger.Debug().Str("resource", resource).Str("item", item).Msg("already deleted")
+					delete(failed, item)
+				}
+			}
+
+			mu.Lock()
+			if err != nil {
+				failures.fail(err, batch...)
+			}
+			for item, err := range failed {
+				failures[item] = err
+			}
+			mu.Unlock()
+
+			n := len(failed)
+			if err != nil {
+				n = len(batch)
+			}
+			p.add(len(batch), n)
+		}()
+	}
+
+	wg.Wait()
+	if !streaming {
+		p.log("deletion finished")
+	}
+
+	return failures
+}
+
+// progress logs how far a deletion got, at most once per progressInterval.
+// total is zero while streaming, when the number of items is not known up
+// front and listed counts the items listed so far instead.
+type progress struct {
+	resource string
+	region   string
+	total    int
+
+	mu     sync.Mutex
+	listed int
+	done   int
+	failed int
+	logged time.Time
+}
+
+type progressKey struct{}
+
+func withProgress(ctx context.Context, p *progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+func (p *progress) list(listed int) {
+	p.update(listed, 0, 0)
+}
+
+func (p *progress) add(done, failed int) {
+	p.update(0, done, failed)
+}
+
+func (p *progress) update(listed, done, failed int) {
+	p.mu.Lock()
+	p.listed += listed
+	p.done += done
+	p.failed += failed
+	due := time.Since(p.logged) >= progressInterval
+	if due {
+		p.logged = time.Now()
+	}
+	p.mu.Unlock()
+
+	if due {
+		p.log("deletion progress")
+	}
+}
+
+func (p *progress) log(msg string) {
+	p.mu.Lock()
+	listed, done, failed := p.listed, p.done, p.failed
+	p.mu.Unlock()
+
+	e := logger.Logger.Info().
+		Str("resource", p.resource).
+		Str("region", p.region).
+		Int("done", done).
+		Int("failed", failed)
+
+	if p.total > 0 {
+		e = e.Int("total", p.total).Float64("percent", float64(done)/float64(p.total)*100)
+	} else {
+		e = e.Int("listed", listed)
+	}
+
+	e.Msg(msg)
+}
+
+// gone reports whether deleting failed because the item no longer exists,
+// which is what deleting it was for, e.g. when a resumed run deletes an item
+// the interrupted run already deleted.
+func gone(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	code := strings.ToLower(strings.ReplaceAll(apiErr.ErrorCode(), "_", ""))
+	return strings.Contains(code, "notfound")
+}
@@ -0,0 +1,163 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stxkxs/ok-cli/logger"
+	"regexp"
+	"strings"
+)
+
+// Protect lists resources tidy must never touch. Names are globs matched
+// against the resource name (and the owning project or report group for
+// codebuild builds and reports), arns match the resource or its owner
+// exactly, in the account and region they name when they name one, and tags
+// are tag selector terms such as stxkxs.io:protect=true.
+type Protect struct {
+	Names []string `mapstructure:"names"`
+	Arns  []string `mapstructure:"arns"`
+	Tags  []string `mapstructure:"tags"`
+
+	names []*regexp.Regexp
+}
+
+func (p Protect) Merge(o Protect) Protect {
+	return Protect{
+		Names: append(append([]string{}, p.Names...), o.Names...),
+		Arns:  append(append([]string{}, p.Arns...), o.Arns...),
+		Tags:  append(append([]string{}, p.Tags...), o.Tags...),
+	}
+}
+
+// filter drops protected items and logs the rule that protected each one.
+// service is the arn service of the items and resourceType their tagging
+// api type, name maps an item to the resource carrying its tags. account is
+// only resolved when an arn rule names one.
+func (p Protect) filter(ctx context.Context, cfg aws.Config, account *callerAccount, service, resourceType string, items []string, name func(string) string) ([]string, error) {
+	if len(items) == 0 {
+		return items, nil
+	}
+
+	var id string
+	if p.accounts() {
+		var err error
+		if id, err = account.get(); err != nil {
+			return nil, err
+		}
+	}
+
+	tagged := make(map[string]string)
+	for _, rule := range p.Tags {
+		names, err := taggedNames(ctx, cfg, rule, resourceType)
+		if err != nil {
+			return nil, err
+		}
+
+		for n := range names {
+			tagged[n] = "tag " + rule
+		}
+	}
+
+	var kept []string
+	for _, item := range items {
+		if rule, ok := p.rule(cfg.Region, id, service, item, name(item), tagged); ok {
+			logger.Logger.Info().
+				Str("resource", item).
+				Str("rule", rule).
+				Msg("skipping protected resource")
+			skipped(ctx, item, rule)
+			continue
+		}
+
+		kept = append(kept, item)
+	}
+
+	return kept, nil
+}
+
+func (p Protect) rule(region, account, service, item, owner string, tagged map[string]string) (string, bool) {
+	if rule, ok := p.named(region, account, service, item, owner); ok {
+		return rule, true
+	}
+
+	if rule, ok := tagged[owner]; ok {
+		return rule, true
+	}
+
+	return "", false
+}
+
+// protects checks an item whose tags were already fetched, for services the
+// tagging api does not cover.
+func (p Protect) protects(region, account, service, item string, tags map[string]string) (string, bool) {
+	if rule, ok := p.named(region, account, service, item, item); ok {
+		return rule, true
+	}
+
+	for _, rule := range p.Tags {
+		if s, err := ParseTagSelector(rule); err == nil && s.Matches(tags) {
+			return "tag " + rule, true
+		}
+	}
+
+	return "", false
+}
+
+func (p Protect) named(region, account, service, item, owner string) (string, bool) {
+	for i, re := range p.globs() {
+		if re.MatchString(item) || re.MatchString(owner) {
+			return "name " + p.Names[i], true
+		}
+	}
+
+	for _, arn := range p.Arns {
+		parts := strings.SplitN(arn, ":", 6)
+		if len(parts) < 6 || parts[2] != service || (parts[3] != "" && parts[3] != region) || (parts[4] != "" && parts[4] != account) {
+			continue
+		}
+
+		if n := nameFromArn(arn); n == item || n == owner {
+			return "arn " + arn, true
+		}
+	}
+
+	return "", false
+}
+
+// globs returns the compiled name globs, compiling them when Validate did
+// not.
+func (p Protect) globs() []*regexp.Regexp {
+	if len(p.names) == len(p.Names) {
+		return p.names
+	}
+
+	names := make([]*regexp.Regexp, 0, len(p.Names))
+	for _, n := range p.Names {
+		names = append(names, glob(n))
+	}
+	return names
+}
+
+// accounts reports whether an arn rule names an account, the only case in
+// which filter needs the account of the credentials.
+func (p Protect) accounts() bool {
+	for _, arn := range p.Arns {
+		if parts := strings.SplitN(arn, ":", 6); len(parts) == 6 && parts[4] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the tag rules and compiles the name globs once.
+func (p *Protect) Validate() error {
+	for _, rule := range p.Tags {
+		if _, err := ParseTagSelector(rule); err != nil {
+			return fmt.Errorf("invalid protect tag %q: %w", rule, err)
+		}
+	}
+
+	p.names = p.globs()
+	return nil
+}
@@ -0,0 +1,42 @@
+package aws
+
+import (
+	"testing"
+)
+
+func TestProtectNamed(t *testing.T) {
+	p := Protect{
+		Names: []string{"/aws/lambda/keep-*"},
+		Arns: []string{
+			"arn:aws:logs:us-west-2:111111111111:log-group:/tidy/pinned:*",
+			"arn:aws:logs::222222222222:log-group:/tidy/everywhere",
+			"arn:aws:logs:us-west-2::log-group:/tidy/any-account",
+		},
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		region  string
+		account string
+		item    string
+		want    bool
+	}{
+		{name: "glob", region: "us-west-2", account: "111111111111", item: "/aws/lambda/keep-me", want: true},
+		{name: "glob miss", region: "us-west-2", account: "111111111111", item: "/aws/lambda/drop-me"},
+		{name: "arn", region: "us-west-2", account: "111111111111", item: "/tidy/pinned", want: true},
+		{name: "arn in another account", region: "us-west-2", account: "333333333333", item: "/tidy/pinned"},
+		{name: "arn in another region", region: "us-east-1", account: "111111111111", item: "/tidy/pinned"},
+		{name: "arn without region", region: "eu-west-1", account: "222222222222", item: "/tidy/everywhere", want: true},
+		{name: "arn without region in another account", region: "eu-west-1", account: "111111111111", item: "/tidy/everywhere"},
+		{name: "arn without account", region: "us-west-2", account: "333333333333", item: "/tidy/any-account", want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, got := p.named(tc.region, tc.account, "logs", tc.item, tc.item); got != tc.want {
+				t.Errorf("named(%s, %s, %s) = %v, want %v", tc.region, tc.account, tc.item, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Reaper removes one type of resource from one region. List finds the
+// candidates, Filter narrows them down by tags, protection, and retention,
+// Delete removes them, and Describe renders a single item for logs and
+// reports. Items are opaque strings owned by the reaper, so plans can carry
+// them between runs.
+type Reaper interface {
+	List(ctx context.Context) ([]string, error)
+	Filter(ctx context.Context, items []string) ([]string, error)
+	Delete(ctx context.Context, items []string) error
+	Describe(item string) string
+}
+
+// ReaperConfig is a tidy config section. Scope reports the regions the
+// section targets, Defaults applies the tidy wide tag selector, protect
+// rules, and grace period, and Reaper builds the reaper for a single region.
+type ReaperConfig interface {
+	Scope() (region string, regions []string)
+	Defaults(tags string, protect Protect, grace Grace)
+	Validate() error
+	Reaper(region string, credentials aws.CredentialsProvider) Reaper
+}
+
+// Reap lists, filters, and deletes in one pass.
+func Reap(ctx context.Context, r Reaper) ([]string, error) {
+	items, err := Candidates(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, r.Delete(ctx, items)
+}
+
+// Candidates lists and filters the items a reaper would delete.
+func Candidates(ctx context.Context, r Reaper) ([]string, error) {
+	items, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Filter(ctx, items)
+}
+
+// Pager is implemented by reapers that can list their items a page at a
+// time, so Stream can start deleting before listing finishes.
+type Pager interface {
+	Pages(ctx context.Context, page func(items []string) error) error
+}
+
+// Reconciler is implemented by reapers whose deletions keep running after
+// the call that started them. Reconcile checks items an interrupted run may
+// have left deleting against their live state, waits for the deletions still
+// in progress, and returns the items that are gone and those that still need
+// deleting.
+type Reconciler interface {
+	Reconcile(ctx context.Context, items []string) (gone, remaining []string, err error)
+}
+
+// Reconcile reconciles items with r, or returns every item as remaining when
+// r deletes synchronously.
+func Reconcile(ctx context.Context, r Reaper, items []string) (gone, remaining []string, err error) {
+	if len(items) == 0 {
+		return nil, nil, nil
+	}
+
+	if rc, ok := r.(Reconciler); ok {
+		return rc.Reconcile(ctx, items)
+	}
+	return nil, items, nil
+}
+
+// Checker is implemented by reapers that can look items up regardless of
+// what the section selects. Gone returns the items that no longer exist, so
+// an item deleted since it was planned is told from one the configuration no
+// longer lists.
+type Checker interface {
+	Gone(ctx context.Context, items []string) ([]string, error)
+}
+
+// Gone returns the items r knows to be gone. Without a Checker no item is
+// known to be gone.
+func Gone(ctx context.Context, r Reaper, items []string) ([]string, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	if c, ok := r.(Checker); ok {
+		return c.Gone(ctx, items)
+	}
+	return nil, nil
+}
+
+// Sizer is implemented by reapers that know how many bytes an item stores,
+// as seen by the last call to List.
+type Sizer interface {
+	Size(item string) int64
+}
+
+// Changer is implemented by reapers that change items rather than delete
+// them. Previous describes an item before the change, as seen by the last
+// call to List.
+type Changer interface {
+	Previous(item string) string
+}
+
+// Forgetter is implemented by reapers that hold on to what listing learned
+// about items. Streams have them forget items once they were filtered out or
+// deleted, so memory stays bounded.
+type Forgetter interface {
+	Forget(items []string)
+}
+
+// listed holds what listing learned about each item. Streams list and delete
+// at the same time, so every access is locked.
+type listed[V any] struct {
+	mu    sync.Mutex
+	items map[string]V
+}
+
+func newListed[V any]() *listed[V] {
+	return &listed[V]{items: make(map[string]V)}
+}
+
+func (l *listed[V]) set(item string, v V) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items[item] = v
+}
+
+func (l *listed[V]) get(item string) (V, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.items[item]
+	return v, ok
+}
+
+func (l *listed[V]) forget(items []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, item := range items {
+		delete(l.items, item)
+	}
+}
+
+type skippedKey struct{}
+
+// WithSkipped returns a context that reports every item a reaper skips, and
+// the reason, to fn. fn may be called from several goroutines.
+func WithSkipped(ctx context.Context, fn func(item, reason string)) context.Context {
+	return context.WithValue(ctx, skippedKey{}, fn)
+}
+
+func skipped(ctx context.Context, item, reason string) {
+	if fn, ok := ctx.Value(skippedKey{}).(func(item, reason string)); ok {
+		fn(item, reason)
+	}
+}
+
+// Failures collects the items a reaper could not delete along with the
+// reason, so that one failed item does not stop the rest of a section.
+type Failures map[string]error
+
+func (f Failures) Error() string {
+	items := slices.Sorted(maps.Keys(f))
+
+	reasons := make([]string, 0, len(items))
+	for _, item := range items {
+		reasons = append(reasons, fmt.Sprintf("%s: %v", item, f[item]))
+	}
+
+	return fmt.Sprintf("%d items failed: %s", len(f), strings.Join(reasons, "; "))
+}
+
+// fail records err for every item.
+func (f Failures) fail(err error, items ...string) {
+	for _, item := range items {
+		f[item] = err
+	}
+}
+
+// merge records the outcome of deleting items: the failures err carries, or
+// err for every item when it failed them all.
+func (f Failures) merge(items []string, err error) {
+	var failed Failures
+	if errors.As(err, &failed) {
+		maps.Copy(f, failed)
+		return
+	}
+
+	if err != nil {
+		f.fail(err, items...)
+	}
+}
+
+// err returns nil when nothing failed, so callers can return it directly.
+func (f Failures) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+	return f
+}
+
+func validate(tags string, p *Protect) error {
+	if tags != "" {
+		if _, err := ParseTagSelector(tags); err != nil {
+			return fmt.Errorf("invalid tags %q: %w", tags, err)
+		}
+	}
+
+	return p.Validate()
+}
+
+func defaults(tags *string, protect *Protect, t string, p Protect) {
+	if *tags == "" {
+		*tags = t
+	}
+	*protect = protect.Merge(p)
+}
@@ -0,0 +1,50 @@
+package aws
+
+import (
+	"cmp"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stxkxs/ok-cli/logger"
+	"slices"
+	"strings"
+)
+
+const allRegions = "all"
+
+// Regions resolves the regions a section targets. regions takes precedence
+// over the single region, and the special value all expands to every region
+// enabled for the account via ec2:DescribeRegions.
+func Regions(region string, regions []string, credentials aws.CredentialsProvider) ([]string, error) {
+	if len(regions) == 0 {
+		return []string{region}, nil
+	}
+
+	if !slices.ContainsFunc(regions, func(r string) bool { return strings.EqualFold(r, allRegions) }) {
+		return slices.Compact(slices.Sorted(slices.Values(regions))), nil
+	}
+
+	ctx := context.Background()
+
+	cfg, err := loadConfig(ctx, region, 0, credentials)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Region = cmp.Or(cfg.Region, fallbackRegion)
+
+	resp, err := ec2.NewFromConfig(cfg).DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("error describing enabled regions")
+		return nil, err
+	}
+
+	var enabled []string
+	for _, r := range resp.Regions {
+		enabled = append(enabled, aws.ToString(r.RegionName))
+	}
+	slices.Sort(enabled)
+
+	logger.Logger.Debug().Strs("regions", enabled).Msg("resolved enabled regions")
+
+	return enabled, nil
+}
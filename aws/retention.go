@@ -0,0 +1,32 @@
+package aws
+
+import "time"
+
+// Retention limits deletion to resources that have aged out. OlderThan and
+// IdleFor accept ages such as 14d or 36h, see ParseAge. Not every section
+// supports every rule: codebuild honors OlderThan and KeepLast (per project),
+// cloudformation honors OlderThan (against the last update), cloudwatch
+// honors IdleFor (against the most recent log event), and ecr honors
+// OlderThan (against the yyyymmdd tag) and KeepLast (per repository).
+type Retention struct {
+	OlderThan string `mapstructure:"olderThan"`
+	KeepLast  int    `mapstructure:"keepLast"`
+	IdleFor   string `mapstructure:"idleFor"`
+}
+
+func (r Retention) olderThan() (time.Time, error) {
+	return before(r.OlderThan)
+}
+
+func (r Retention) idleFor() (time.Time, error) {
+	return before(r.IdleFor)
+}
+
+func before(age string) (time.Time, error) {
+	d, err := ParseAge(age)
+	if err != nil || d == 0 {
+		return time.Time{}, err
+	}
+
+	return time.Now().Add(-d), nil
+}
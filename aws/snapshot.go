@@ -0,0 +1,284 @@
+package aws
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stxkxs/ok-cli/logger"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSnapshotDir = "~/.ok/archive/stacks"
+
+	// maskedParameter is what cloudformation returns for NoEcho parameters.
+	maskedParameter = "****"
+
+	// templateBodyLimit is the largest template CreateStack accepts inline.
+	templateBodyLimit = 51200
+)
+
+// Snapshot saves the template, parameters, tags, and outputs of a stack under
+// Dir, ~/.ok/archive/stacks by default, before the stack is deleted, keyed by
+// account, region, stack, and time. A stack is only deleted once its
+// snapshot was saved, unless snapshots are Disabled.
+type Snapshot struct {
+	Dir      string `mapstructure:"dir"`
+	Disabled bool   `mapstructure:"disabled"`
+}
+
+// StackSnapshot is everything needed to create a deleted stack again.
+type StackSnapshot struct {
+	Created      time.Time         `json:"created"`
+	Account      string            `json:"account"`
+	Region       string            `json:"region"`
+	Stack        string            `json:"stack"`
+	StackId      string            `json:"stackId"`
+	Description  string            `json:"description,omitempty"`
+	Capabilities []string          `json:"capabilities,omitempty"`
+	RoleArn      string            `json:"roleArn,omitempty"`
+	Parameters   map[string]string `json:"parameters,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Outputs      []StackOutput     `json:"outputs,omitempty"`
+	Template     string            `json:"template"`
+}
+
+type StackOutput struct {
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description,omitempty"`
+	Export      string `json:"export,omitempty"`
+}
+
+// Restore creates a stack from a snapshot. Name overrides the stack name,
+// Parameters override snapshot parameters, which NoEcho parameters need
+// since cloudformation masks their values, and Bucket stages templates too
+// large to pass inline. Credentials default to the default credential chain.
+type Restore struct {
+	Name        string
+	Bucket      string
+	Parameters  map[string]string
+	Timeout     time.Duration
+	Credentials aws.CredentialsProvider
+}
+
+// save snapshots a stack of account and returns the file it was written to.
+func (s Snapshot) save(ctx context.Context, api *cloudformation.Client, c CloudFormation, account, stackName string) (string, error) {
+	stacks, err := api.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{StackName: &stackName})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("stack", stackName).Msg("error describing stack")
+		return "", err
+	}
+
+	if len(stacks.Stacks) == 0 {
+		return "", fmt.Errorf("stack not found: %s", stackName)
+	}
+
+	template, err := api.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     &stackName,
+		TemplateStage: types.TemplateStageOriginal,
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("stack", stackName).Msg("error getting stack template")
+		return "", err
+	}
+
+	stack := stacks.Stacks[0]
+	snap := StackSnapshot{
+		Created:     time.Now().UTC(),
+		Account:     account,
+		Region:      c.Region,
+		Stack:       stackName,
+		StackId:     aws.ToString(stack.StackId),
+		Description: aws.ToString(stack.Description),
+		RoleArn:     aws.ToString(stack.RoleARN),
+		Parameters:  make(map[string]string, len(stack.Parameters)),
+		Tags:        make(map[string]string, len(stack.Tags)),
+		Template:    aws.ToString(template.TemplateBody),
+	}
+
+	for _, capability := range stack.Capabilities {
+		snap.Capabilities = append(snap.Capabilities, string(capability))
+	}
+
+	for _, p := range stack.Parameters {
+		snap.Parameters[aws.ToString(p.ParameterKey)] = aws.ToString(p.ParameterValue)
+	}
+
+	for _, t := range stack.Tags {
+		snap.Tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+
+	for _, o := range stack.Outputs {
+		snap.Outputs = append(snap.Outputs, StackOutput{
+			Key:         aws.ToString(o.OutputKey),
+			Value:       aws.ToString(o.OutputValue),
+			Description: aws.ToString(o.Description),
+			Export:      aws.ToString(o.ExportName),
+		})
+	}
+
+	dir, err := expandHome(cmp.Or(s.Dir, defaultSnapshotDir))
+	if err != nil {
+		return "", err
+	}
+
+	file := filepath.Join(dir, account, c.Region, stackName, snap.Created.Format(archiveTimestamp)+".json")
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(file, b, 0o600); err != nil {
+		return "", err
+	}
+
+	logger.Logger.Info().
+		Str("stack", stackName).
+		Str("snapshot", file).
+		Msg("saved stack snapshot")
+
+	return file, nil
+}
+
+func ReadStackSnapshot(file string) (*StackSnapshot, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var snap StackSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return nil, fmt.Errorf("invalid stack snapshot %s: %w", file, err)
+	}
+
+	if snap.Stack == "" || snap.Region == "" || snap.Template == "" {
+		return nil, fmt.Errorf("invalid stack snapshot %s: missing stack, region, or template", file)
+	}
+
+	return &snap, nil
+}
+
+// RestoreStack creates the stack of a snapshot in the account and region it
+// was taken in and waits for the creation to complete.
+func RestoreStack(ctx context.Context, snap *StackSnapshot, r Restore) error {
+	cfg, err := loadConfig(ctx, snap.Region, 0, r.Credentials)
+	if err != nil {
+		return err
+	}
+
+	account, err := AccountId(r.Credentials)
+	if err != nil {
+		return err
+	}
+
+	if snap.Account != "" && account != snap.Account {
+		return fmt.Errorf("snapshot of stack %s was taken in account %s, current credentials belong to %s", snap.Stack, snap.Account, account)
+	}
+
+	name := cmp.Or(r.Name, snap.Stack)
+	input := &cloudformation.CreateStackInput{StackName: &name}
+
+	var masked []string
+	for _, key := range slices.Sorted(maps.Keys(snap.Parameters)) {
+		value := snap.Parameters[key]
+		if v, ok := r.Parameters[key]; ok {
+			value = v
+		} else if value == maskedParameter {
+			masked = append(masked, key)
+		}
+
+		input.Parameters = append(input.Parameters, types.Parameter{ParameterKey: aws.String(key), ParameterValue: aws.String(value)})
+	}
+
+	if len(masked) > 0 {
+		return fmt.Errorf("stack %s has NoEcho parameters %s, pass their values with --parameter", snap.Stack, strings.Join(masked, ", "))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(snap.Tags)) {
+		if strings.HasPrefix(key, "aws:") {
+			continue
+		}
+		input.Tags = append(input.Tags, types.Tag{Key: aws.String(key), Value: aws.String(snap.Tags[key])})
+	}
+
+	for _, capability := range snap.Capabilities {
+		input.Capabilities = append(input.Capabilities, types.Capability(capability))
+	}
+
+	if snap.RoleArn != "" {
+		input.RoleARN = &snap.RoleArn
+	}
+
+	switch {
+	case r.Bucket != "":
+		url, err := stageTemplate(ctx, cfg, r.Bucket, snap)
+		if err != nil {
+			return err
+		}
+		input.TemplateURL = &url
+	case len(snap.Template) > templateBodyLimit:
+		return fmt.Errorf("template of stack %s is %d bytes, over the %d byte inline limit, pass --bucket to stage it in s3", snap.Stack, len(snap.Template), templateBodyLimit)
+	default:
+		input.TemplateBody = &snap.Template
+	}
+
+	api := cloudformation.NewFromConfig(cfg)
+	if _, err := api.CreateStack(ctx, input); err != nil {
+		logger.Logger.Error().Err(err).Str("stack", name).Msg("error creating stack")
+		return err
+	}
+
+	logger.Logger.Info().
+		Str("stack", name).
+		Str("region", snap.Region).
+		Msg("initiated restore of stack")
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = defaultStackTimeout
+	}
+
+	waiter := cloudformation.NewStackCreateCompleteWaiter(api)
+	if err := waiter.Wait(ctx, &cloudformation.DescribeStacksInput{StackName: &name}, timeout); err != nil {
+		status, _ := getStackStatus(ctx, api, name)
+		logger.Logger.Error().Err(err).Str("stack", name).Str("status", status).Msg("error waiting for stack creation")
+		return err
+	}
+
+	logger.Logger.Info().Str("stack", name).Msg("restored stack")
+	return nil
+}
+
+// stageTemplate uploads the template of a snapshot to s3 and returns its url.
+func stageTemplate(ctx context.Context, cfg aws.Config, bucket string, snap *StackSnapshot) (string, error) {
+	key := path.Join("ok-tidy-restore", snap.Account, snap.Region, snap.Stack, snap.Created.Format(archiveTimestamp)+".template")
+
+	_, err := s3.NewFromConfig(cfg).PutObject(ctx, &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+		Body:   bytes.NewReader([]byte(snap.Template)),
+	})
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("bucket", bucket).Str("key", key).Msg("error staging stack template")
+		return "", err
+	}
+
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, cfg.Region, key), nil
+}
@@ -0,0 +1,286 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/smithy-go"
+	"github.com/stxkxs/ok-cli/logger"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultStackParallelism = 4
+
+// stackGraph orders stack deletion around cross-stack references. A stack
+// that imports another stack's export must be deleted before the exporter.
+// Nested stacks are folded into their root, since cloudformation deletes
+// them along with it.
+type stackGraph struct {
+	stacks    []string
+	producers map[string][]string
+	consumers map[string]int
+	blocked   map[string]string
+}
+
+type deleted struct {
+	stack string
+	err   error
+}
+
+func newStackGraph(ctx context.Context, api *cloudformation.Client, stackNames []string) (*stackGraph, error) {
+	roots, err := stackRoots(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &stackGraph{
+		producers: make(map[string][]string),
+		consumers: make(map[string]int),
+		blocked:   make(map[string]string),
+	}
+
+	targets := make(map[string]bool)
+	for _, name := range stackNames {
+		targets[name] = true
+	}
+
+	for _, name := range stackNames {
+		if root := roots[name]; root != name && targets[root] {
+			logger.Logger.Debug().Str("stack", name).Str("root", root).Msg("deleting nested stack with its root")
+			continue
+		}
+		g.stacks = append(g.stacks, name)
+	}
+
+	input := &cloudformation.ListExportsInput{}
+	for {
+		resp, err := api.ListExports(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("error listing stack exports")
+			return nil, err
+		}
+
+		for _, export := range resp.Exports {
+			producer := root(roots, nameFromArn(aws.ToString(export.ExportingStackId)))
+			if !slices.Contains(g.stacks, producer) {
+				continue
+			}
+
+			importers, err := listImports(ctx, api, aws.ToString(export.Name))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, importer := range importers {
+				consumer := root(roots, importer)
+				if consumer == producer {
+					continue
+				}
+
+				if !slices.Contains(g.stacks, consumer) {
+					g.blocked[producer] = fmt.Sprintf("export %s is imported by %s which is not being deleted", aws.ToString(export.Name), importer)
+					continue
+				}
+
+				if !slices.Contains(g.producers[consumer], producer) {
+					g.producers[consumer] = append(g.producers[consumer], producer)
+					g.consumers[producer]++
+				}
+			}
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return g, nil
+}
+
+// delete removes every stack in the graph, consumers before producers, with
+// up to c.Parallelism deletions in flight. When a stack cannot be deleted the
+// stacks it imports from are skipped as well, and with the abort policy no
+// further deletions are started. Snapshots are saved under account.
+func (g *stackGraph) delete(ctx context.Context, api *cloudformation.Client, c CloudFormation, account string) error {
+	parallelism := c.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultStackParallelism
+	}
+
+	failures := make(Failures)
+	skipped := make(map[string]bool)
+
+	var skip func(stack, reason string)
+	skip = func(stack, reason string) {
+		if skipped[stack] {
+			return
+		}
+		skipped[stack] = true
+
+		logger.Logger.Warn().Str("stack", stack).Str("reason", reason).Msg("skipping stack deletion")
+		failures.fail(fmt.Errorf("skipped: %s", reason), stack)
+
+		for _, producer := range g.producers[stack] {
+			skip(producer, fmt.Sprintf("imported by %s which was not deleted", stack))
+		}
+	}
+
+	for _, stack := range g.stacks {
+		if reason, ok := g.blocked[stack]; ok {
+			skip(stack, reason)
+		}
+	}
+
+	var ready []string
+	for _, stack := range g.stacks {
+		if g.consumers[stack] == 0 && !skipped[stack] {
+			ready = append(ready, stack)
+		}
+	}
+
+	p := &progress{resource: "stack", region: c.Region, total: len(g.stacks), logged: time.Now()}
+
+	done := make(chan deleted)
+	running := 0
+	aborted := false
+	started := make(map[string]bool)
+	finished := make(map[string]bool)
+
+	for (len(ready) > 0 && !aborted && ctx.Err() == nil) || running > 0 {
+		for len(ready) > 0 && running < parallelism && !aborted && ctx.Err() == nil {
+			stack := ready[0]
+			ready = ready[1:]
+			running++
+			started[stack] = true
+
+			go func() {
+				done <- deleted{stack: stack, err: deleteStack(ctx, api, c, account, stack)}
+			}()
+		}
+
+		if running == 0 {
+			break
+		}
+
+		d := <-done
+		running--
+		finished[d.stack] = true
+
+		if d.err != nil {
+			failures.fail(d.err, d.stack)
+			p.add(1, 1)
+
+			if strings.EqualFold(c.OnDeleteFailed, DeleteFailedAbort) && !aborted {
+				aborted = true
+				logger.Logger.Error().Str("stack", d.stack).Msg("aborting stack deletion after failure")
+			}
+
+			for _, producer := range g.producers[d.stack] {
+				skip(producer, fmt.Sprintf("imported by %s which failed to delete", d.stack))
+			}
+			continue
+		}
+
+		p.add(1, 0)
+		for _, producer := range g.producers[d.stack] {
+			g.consumers[producer]--
+			if g.consumers[producer] == 0 && !skipped[producer] {
+				ready = append(ready, producer)
+			}
+		}
+	}
+
+	if aborted {
+		for _, stack := range g.stacks {
+			if !started[stack] {
+				skip(stack, "aborted after an earlier stack failed to delete")
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		for _, stack := range g.stacks {
+			if !started[stack] {
+				skip(stack, "deletion cancelled: "+err.Error())
+			}
+		}
+	}
+
+	for _, stack := range g.stacks {
+		if !finished[stack] && !skipped[stack] {
+			failures.fail(errors.New("never deleted because of a dependency cycle"), stack)
+		}
+	}
+
+	p.log("deletion finished")
+
+	return failures.err()
+}
+
+// stackRoots maps every stack name to the name of its root stack.
+func stackRoots(ctx context.Context, api *cloudformation.Client) (map[string]string, error) {
+	roots := make(map[string]string)
+	input := &cloudformation.DescribeStacksInput{}
+
+	for {
+		resp, err := api.DescribeStacks(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Msg("error listing stacks")
+			return nil, err
+		}
+
+		for _, stack := range resp.Stacks {
+			name := aws.ToString(stack.StackName)
+			roots[name] = name
+			if stack.RootId != nil {
+				roots[name] = nameFromArn(*stack.RootId)
+			}
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return roots, nil
+}
+
+func root(roots map[string]string, stack string) string {
+	if r, ok := roots[stack]; ok {
+		return r
+	}
+	return stack
+}
+
+func listImports(ctx context.Context, api *cloudformation.Client, export string) ([]string, error) {
+	var imports []string
+	input := &cloudformation.ListImportsInput{ExportName: &export}
+
+	for {
+		resp, err := api.ListImports(ctx, input)
+		if err != nil {
+			var apiError smithy.APIError
+			if errors.As(err, &apiError) && strings.Contains(apiError.ErrorMessage(), "is not imported") {
+				return imports, nil
+			}
+
+			logger.Logger.Error().Err(err).Str("export", export).Msg("error listing stack imports")
+			return nil, err
+		}
+
+		imports = append(imports, resp.Imports...)
+
+		if resp.NextToken == nil {
+			break
+		}
+		input.NextToken = resp.NextToken
+	}
+
+	return imports, nil
+}
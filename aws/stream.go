@@ -0,0 +1,116 @@
+package aws
+
+import (
+	"context"
+	"time"
+)
+
+// streamBuffer is how many filtered pages may wait for deletion before
+// listing pauses.
+const streamBuffer = 4
+
+// Hooks follow the items of a stream. Planned gets every filtered page
+// before it is queued, Deleting every page right before it is deleted, and
+// Deleted every page along with the outcome of deleting it. Every hook must
+// be set.
+type Hooks struct {
+	Planned  func(items []string)
+	Deleting func(items []string)
+	Deleted  func(items []string, err error)
+}
+
+// Stream lists, filters, and deletes the items of r page by page. Listing
+// runs ahead of deletion by at most streamBuffer pages, so memory stays
+// bounded however many items a region holds. Reapers that do not implement
+// Pager are listed in full first. Stream returns how many items were planned
+// for deletion.
+func Stream(ctx context.Context, resource, region string, r Reaper, h Hooks) (int, error) {
+	pager, ok := r.(Pager)
+	if !ok {
+		items, err := Candidates(ctx, r)
+		if err != nil {
+			return 0, err
+		}
+
+		h.Planned(items)
+		h.Deleting(items)
+		err = r.Delete(ctx, items)
+		h.Deleted(items, err)
+		return len(items), err
+	}
+
+	p := &progress{resource: resource, region: region, logged: time.Now()}
+	ctx = withProgress(withTagCache(ctx), p)
+
+	pages := make(chan []string, streamBuffer)
+	listed := make(chan error, 1)
+
+	go func() {
+		defer close(pages)
+
+		listed <- pager.Pages(ctx, func(page []string) error {
+			p.list(len(page))
+
+			items, err := r.Filter(ctx, page)
+			if err != nil {
+				return err
+			}
+
+			forget(r, dropped(page, items))
+			if len(items) == 0 {
+				return nil
+			}
+
+			h.Planned(items)
+
+			select {
+			case pages <- items:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	count := 0
+	failures := make(Failures)
+	for items := range pages {
+		count += len(items)
+
+		h.Deleting(items)
+		err := r.Delete(ctx, items)
+		h.Deleted(items, err)
+		failures.merge(items, err)
+		forget(r, items)
+	}
+
+	p.log("deletion finished")
+
+	if err := <-listed; err != nil {
+		return count, err
+	}
+
+	return count, failures.err()
+}
+
+func forget(r Reaper, items []string) {
+	if f, ok := r.(Forgetter); ok && len(items) > 0 {
+		f.Forget(items)
+	}
+}
+
+// dropped returns the listed items that filtering dropped.
+func dropped(listed, kept []string) []string {
+	keep := make(map[string]bool, len(kept))
+	for _, item := range kept {
+		keep[item] = true
+	}
+
+	var items []string
+	for _, item := range listed {
+		if !keep[item] {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,21 +1,38 @@
 package aws
 
 import (
+	"cmp"
 	"context"
+	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/rs/zerolog/log"
 	"github.com/stxkxs/ok-cli/logger"
+	"sync"
 )
 
+// fallbackRegion reaches sts and ec2:DescribeRegions when neither the
+// environment nor the profile sets a region, so resolving accounts and
+// regions does not depend on one.
+const fallbackRegion = "us-east-1"
+
 type StsClient struct {
 	Client *sts.Client
 }
 
+// Account is a target account reached by assuming Role.
+type Account struct {
+	Id         string `mapstructure:"id"`
+	Role       string `mapstructure:"role"`
+	ExternalId string `mapstructure:"externalId"`
+	Session    string `mapstructure:"session"`
+}
+
 func NewStsClient() *StsClient {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	cfg, err := config.LoadDefaultConfig(context.Background(), WithRateLimit())
 	if err != nil {
 		log.Error().Err(err).Msg("error loading default aws configurations")
 		return nil
@@ -26,7 +43,7 @@ func NewStsClient() *StsClient {
 }
 
 func NewStsClientFromRole(role, externalId, session string) *StsClient {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	cfg, err := config.LoadDefaultConfig(context.Background(), WithRateLimit())
 	if err != nil {
 		log.Error().Err(err).Msg("error loading default aws configurations")
 		return nil
@@ -53,7 +70,7 @@ func NewStsClientFromRole(role, externalId, session string) *StsClient {
 		*subscriberRoleAssumed.Credentials.SecretAccessKey,
 		*subscriberRoleAssumed.Credentials.SessionToken)
 
-	cfg, err = config.LoadDefaultConfig(context.Background(), config.WithCredentialsProvider(p))
+	cfg, err = config.LoadDefaultConfig(context.Background(), config.WithCredentialsProvider(p), WithRateLimit())
 	if err != nil {
 		log.Error().Err(err).Msg("error loading aws configurations with custom credentials")
 		return nil
@@ -63,6 +80,78 @@ func NewStsClientFromRole(role, externalId, session string) *StsClient {
 	return &StsClient{Client: client}
 }
 
+// Credentials assumes the account role and returns a provider that renews the
+// session before it expires, so long running cleanups outlive the first set
+// of temporary credentials, along with the id of the assumed account. The
+// assumed identity is verified against Id when it is set.
+func (a Account) Credentials() (aws.CredentialsProvider, string, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background(), WithRateLimit())
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("error loading default aws configurations")
+		return nil, "", err
+	}
+	cfg.Region = cmp.Or(cfg.Region, fallbackRegion)
+
+	p := aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), a.Role, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = a.Session
+		if a.ExternalId != "" {
+			o.ExternalID = aws.String(a.ExternalId)
+		}
+	}))
+
+	id, err := AccountId(p)
+	if err != nil {
+		logger.Logger.Error().Err(err).Str("role", a.Role).Msg("error assuming account role")
+		return nil, "", err
+	}
+
+	if a.Id != "" && id != a.Id {
+		return nil, "", fmt.Errorf("role %s belongs to account %s, expected %s", a.Role, id, a.Id)
+	}
+
+	return p, id, nil
+}
+
+// callerAccount resolves the account behind credentials on first use, so a
+// reaper asks sts once however many pages it filters.
+type callerAccount struct {
+	once        sync.Once
+	credentials aws.CredentialsProvider
+	id          string
+	err         error
+}
+
+func newCallerAccount(credentials aws.CredentialsProvider) *callerAccount {
+	return &callerAccount{credentials: credentials}
+}
+
+func (a *callerAccount) get() (string, error) {
+	a.once.Do(func() {
+		a.id, a.err = AccountId(a.credentials)
+	})
+	return a.id, a.err
+}
+
+// AccountId returns the account behind the given credentials, or behind the
+// default credential chain when p is nil.
+func AccountId(p aws.CredentialsProvider) (string, error) {
+	ctx := context.Background()
+
+	cfg, err := loadConfig(ctx, "", 0, p)
+	if err != nil {
+		return "", err
+	}
+	cfg.Region = cmp.Or(cfg.Region, fallbackRegion)
+
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		logger.Logger.Error().Err(err).Msg("error getting caller identity")
+		return "", err
+	}
+
+	return aws.ToString(identity.Account), nil
+}
+
 func (client *StsClient) AssumeRole(role, id, session string) (*sts.AssumeRoleOutput, error) {
 	input := &sts.AssumeRoleInput{
 		ExternalId:      aws.String(id),
@@ -0,0 +1,400 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	"github.com/stxkxs/ok-cli/logger"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	tagTypeLogGroup    = "logs:log-group"
+	tagTypeStack       = "cloudformation:stack"
+	tagTypeProject     = "codebuild:project"
+	tagTypeReportGroup = "codebuild:report-group"
+)
+
+// TagSelector is a boolean expression over resource tags, for example
+//
+//	stxkxs.io:environment=prototype AND NOT stxkxs.io:keep
+//
+// Terms are a bare key (the tag is present), key=value, key!=value, or
+// key IN (value, ...) and may be combined with AND, OR, NOT, and
+// parentheses. AND binds tighter than OR.
+type TagSelector interface {
+	Matches(tags map[string]string) bool
+}
+
+type tagTerm struct {
+	key    string
+	value  string
+	values []string
+	op     string
+}
+
+type tagAnd []TagSelector
+type tagOr []TagSelector
+type tagNot struct{ TagSelector }
+
+func (t tagTerm) Matches(tags map[string]string) bool {
+	v, ok := tags[t.key]
+	switch t.op {
+	case "=":
+		return ok && v == t.value
+	case "!=":
+		return !ok || v != t.value
+	case "in":
+		return ok && slices.Contains(t.values, v)
+	default:
+		return ok
+	}
+}
+
+func (a tagAnd) Matches(tags map[string]string) bool {
+	for _, s := range a {
+		if !s.Matches(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o tagOr) Matches(tags map[string]string) bool {
+	for _, s := range o {
+		if s.Matches(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n tagNot) Matches(tags map[string]string) bool {
+	return !n.TagSelector.Matches(tags)
+}
+
+func ParseTagSelector(expr string) (TagSelector, error) {
+	p := &tagParser{tokens: tokenize(expr)}
+
+	s, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in tag selector %q", p.tokens[p.pos], expr)
+	}
+
+	return s, nil
+}
+
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *tagParser) or() (TagSelector, error) {
+	s, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := tagOr{s}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		s, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, s)
+	}
+
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *tagParser) and() (TagSelector, error) {
+	s, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	terms := tagAnd{s}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		s, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, s)
+	}
+
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *tagParser) unary() (TagSelector, error) {
+	t := p.next()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of tag selector")
+	case strings.EqualFold(t, "not"):
+		s, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{s}, nil
+	case t == "(":
+		s, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ) in tag selector")
+		}
+		return s, nil
+	case t == ")" || strings.EqualFold(t, "and") || strings.EqualFold(t, "or"):
+		return nil, fmt.Errorf("unexpected %q in tag selector", t)
+	}
+
+	if k, v, ok := strings.Cut(t, "!="); ok {
+		return tagTerm{key: k, value: v, op: "!="}, nil
+	}
+
+	if k, v, ok := strings.Cut(t, "="); ok {
+		return tagTerm{key: k, value: v, op: "="}, nil
+	}
+
+	if strings.EqualFold(p.peek(), "in") {
+		p.next()
+		return p.in(t)
+	}
+
+	return tagTerm{key: t}, nil
+}
+
+// in parses the parenthesized, comma separated values of key IN (...).
+func (p *tagParser) in(key string) (TagSelector, error) {
+	if p.next() != "(" {
+		return nil, fmt.Errorf("missing ( after %s IN in tag selector", key)
+	}
+
+	var values []string
+	for t := p.next(); t != ")"; t = p.next() {
+		if t == "" || t == "(" {
+			return nil, fmt.Errorf("missing ) in tag selector")
+		}
+
+		for _, v := range strings.Split(t, ",") {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no values for %s IN in tag selector", key)
+	}
+
+	return tagTerm{key: key, values: values, op: "in"}, nil
+}
+
+func tokenize(expr string) []string {
+	var tokens []string
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range expr {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// selectTagged keeps the items whose owning resource, as derived by name,
+// satisfies the tag selector. An empty selector keeps everything.
+func selectTagged(ctx context.Context, cfg aws.Config, expr, resourceType string, items []string, name func(string) string) ([]string, error) {
+	if expr == "" || len(items) == 0 {
+		return items, nil
+	}
+
+	names, err := taggedNames(ctx, cfg, expr, resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []string
+	for _, item := range items {
+		if names[name(item)] {
+			selected = append(selected, item)
+		} else {
+			logger.Logger.Debug().Str("item", item).Str("tags", expr).Msg("skipping resource not selected by tags")
+			skipped(ctx, item, "not selected by tags "+expr)
+		}
+	}
+
+	return selected, nil
+}
+
+// tagCache remembers resolved tag selectors, and the tags of resources, for
+// the length of a stream, so filtering page by page does not walk the tagging
+// api once per page.
+type tagCache struct {
+	mu    sync.Mutex
+	names map[string]map[string]bool
+	tags  map[string]map[string]map[string]string
+}
+
+type tagCacheKey struct{}
+
+func withTagCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tagCacheKey{}, &tagCache{names: make(map[string]map[string]bool), tags: make(map[string]map[string]map[string]string)})
+}
+
+// taggedNames resolves the names of every resource of the given tagging
+// resource type whose tags satisfy the selector. Resources that never carried
+// a tag are invisible to the tagging api and are therefore never selected.
+func taggedNames(ctx context.Context, cfg aws.Config, expr, resourceType string) (map[string]bool, error) {
+	cache, ok := ctx.Value(tagCacheKey{}).(*tagCache)
+	if !ok {
+		return resolveTagged(ctx, cfg, expr, resourceType)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	k := strings.Join([]string{cfg.Region, resourceType, expr}, "\x00")
+	if names, ok := cache.names[k]; ok {
+		return names, nil
+	}
+
+	names, err := resolveTagged(ctx, cfg, expr, resourceType)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.names[k] = names
+	return names, nil
+}
+
+func resolveTagged(ctx context.Context, cfg aws.Config, expr, resourceType string) (map[string]bool, error) {
+	selector, err := ParseTagSelector(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	api := resourcegroupstaggingapi.NewFromConfig(cfg)
+	input := &resourcegroupstaggingapi.GetResourcesInput{
+		ResourceTypeFilters: []string{resourceType},
+	}
+
+	names := make(map[string]bool)
+	for {
+		resp, err := api.GetResources(ctx, input)
+		if err != nil {
+			logger.Logger.Error().Err(err).Str("type", resourceType).Msg("error getting tagged resources")
+			return nil, err
+		}
+
+		for _, r := range resp.ResourceTagMappingList {
+			tags := make(map[string]string, len(r.Tags))
+			for _, t := range r.Tags {
+				tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+			}
+
+			if selector.Matches(tags) {
+				names[nameFromArn(aws.ToString(r.ResourceARN))] = true
+			}
+		}
+
+		if aws.ToString(resp.PaginationToken) == "" {
+			break
+		}
+		input.PaginationToken = resp.PaginationToken
+	}
+
+	logger.Logger.Debug().
+		Str("type", resourceType).
+		Str("tags", expr).
+		Int("matched", len(names)).
+		Msg("resolved tagged resources")
+
+	return names, nil
+}
+
+func identity(name string) string {
+	return name
+}
+
+// nameFromArn extracts the resource name from log group, stack, project, and
+// report group arns, e.g. arn:aws:logs:us-west-2:000000000000:log-group:/aws/lambda/x:*
+// arn:aws:cloudformation:us-west-2:000000000000:stack/name/id or
+// arn:aws:codebuild:us-west-2:000000000000:project/name. Repository names
+// keep their slashes, e.g. arn:aws:ecr:us-west-2:000000000000:repository/a/b.
+func nameFromArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return arn
+	}
+
+	resource := parts[5]
+	if name, ok := strings.CutPrefix(resource, "log-group:"); ok {
+		return strings.TrimSuffix(name, ":*")
+	}
+
+	if name, ok := strings.CutPrefix(resource, "repository/"); ok {
+		return name
+	}
+
+	segments := strings.Split(resource, "/")
+	if len(segments) > 1 {
+		return segments[1]
+	}
+
+	return resource
+}
+
+// arnAccount extracts the account of an arn, or nothing when it has none.
+func arnAccount(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[4]
+}
@@ -0,0 +1,69 @@
+package aws
+
+import (
+	"testing"
+)
+
+func TestParseTagSelector(t *testing.T) {
+	tags := map[string]string{
+		"stxkxs.io:environment": "prototype",
+		"stxkxs.io:part-of":     "ok",
+		"stxkxs.io:keep":        "",
+	}
+
+	for _, tc := range []struct {
+		expr string
+		want bool
+	}{
+		{expr: "stxkxs.io:keep", want: true},
+		{expr: "stxkxs.io:owner"},
+		{expr: "stxkxs.io:environment=prototype", want: true},
+		{expr: "stxkxs.io:environment=production"},
+		{expr: "stxkxs.io:environment!=production", want: true},
+		{expr: "stxkxs.io:owner!=someone", want: true},
+		{expr: "NOT stxkxs.io:keep"},
+		{expr: "not not stxkxs.io:keep", want: true},
+		{expr: "NOT stxkxs.io:environment=production", want: true},
+		{expr: "stxkxs.io:environment IN (staging, prototype)", want: true},
+		{expr: "stxkxs.io:environment in (staging,production)"},
+		{expr: "stxkxs.io:owner IN (someone)"},
+		{expr: "NOT stxkxs.io:environment IN (staging)", want: true},
+		{expr: "stxkxs.io:environment=prototype AND NOT stxkxs.io:keep"},
+		{expr: "stxkxs.io:environment=prototype and stxkxs.io:part-of=ok", want: true},
+		{expr: "stxkxs.io:owner OR stxkxs.io:part-of=ok", want: true},
+		{expr: "stxkxs.io:owner OR stxkxs.io:keep AND stxkxs.io:environment=production"},
+		{expr: "(stxkxs.io:owner OR stxkxs.io:keep) AND stxkxs.io:environment=prototype", want: true},
+	} {
+		s, err := ParseTagSelector(tc.expr)
+		if err != nil {
+			t.Errorf("ParseTagSelector(%q): %v", tc.expr, err)
+			continue
+		}
+
+		if got := s.Matches(tags); got != tc.want {
+			t.Errorf("%q matches %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParseTagSelectorInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"NOT",
+		"AND stxkxs.io:keep",
+		"stxkxs.io:keep AND",
+		"stxkxs.io:keep OR OR stxkxs.io:owner",
+		"(stxkxs.io:keep",
+		"stxkxs.io:keep)",
+		"()",
+		"stxkxs.io:environment IN",
+		"stxkxs.io:environment IN staging",
+		"stxkxs.io:environment IN ()",
+		"stxkxs.io:environment IN (,)",
+		"stxkxs.io:environment IN (staging",
+	} {
+		if _, err := ParseTagSelector(expr); err == nil {
+			t.Errorf("ParseTagSelector(%q) succeeded, want error", expr)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package docker
 
 import (
+	"cmp"
+	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/stxkxs/ok-cli/aws/ecr"
 	"github.com/stxkxs/ok-cli/logger"
+	"github.com/stxkxs/ok-cli/terminal"
 )
 
 var destroy = &cobra.Command{
@@ -19,17 +22,47 @@ var destroy = &cobra.Command{
 
 		if public {
 			decoded, _ := LoadPrepConf()
-			client := ecr.NewPublicEcrClient(decoded.Public.Region)
+
+			var names []string
 			for _, i := range decoded.Public.Images {
-				client.DestroyRepository(decoded.Account, i.Name)
+				names = append(names, i.Name)
+			}
+
+			title := fmt.Sprintf("public docker repositories to destroy in account %s", decoded.Account)
+			kept, err := terminal.Destroy(title, cmp.Or(decoded.Environment, environment), yes, names)
+			if err != nil {
+				logger.Logger.Error().
+					Err(err).
+					Msg("prep docker destroy cancelled")
+				return
+			}
+
+			client := ecr.NewPublicEcrClient(decoded.Public.Region)
+			for _, name := range kept {
+				client.DestroyRepository(decoded.Account, name)
 			}
 		}
 
 		if private {
 			decoded, _ := LoadPrepConf()
-			client := ecr.NewPrivateEcrClient(decoded.Private.Region)
+
+			var names []string
 			for _, i := range decoded.Private.Images {
-				client.DestroyRepository(decoded.Account, i.Name)
+				names = append(names, i.Name)
+			}
+
+			title := fmt.Sprintf("private docker repositories to destroy in account %s", decoded.Account)
+			kept, err := terminal.Destroy(title, cmp.Or(decoded.Environment, environment), yes, names)
+			if err != nil {
+				logger.Logger.Error().
+					Err(err).
+					Msg("prep docker destroy cancelled")
+				return
+			}
+
+			client := ecr.NewPrivateEcrClient(decoded.Private.Region)
+			for _, name := range kept {
+				client.DestroyRepository(decoded.Account, name)
 			}
 		}
 	},
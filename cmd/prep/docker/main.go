@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/stxkxs/ok-cli/aws"
 	"github.com/stxkxs/ok-cli/aws/ecr"
 	"github.com/stxkxs/ok-cli/env"
 	"github.com/stxkxs/ok-cli/logger"
@@ -13,6 +14,7 @@ var file string
 var environment string
 var public bool
 var private bool
+var yes bool
 
 var Cmd = &cobra.Command{
 	Use:   "docker",
@@ -51,6 +53,14 @@ var Cmd = &cobra.Command{
 				Msg("error fetching private flag")
 			return
 		}
+
+		yes, err = cmd.Flags().GetBool("yes")
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Msg("error fetching yes flag")
+			return
+		}
 	},
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if !public && !private {
@@ -78,6 +88,8 @@ func LoadPrepConf() (ecr.Prep, error) {
 		return conf, err
 	}
 
+	aws.SetRateLimits(conf.RateLimits)
+
 	logger.Logger.Debug().
 		Interface("decoded", conf).
 		Msg("decoded prep conf")
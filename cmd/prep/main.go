@@ -10,6 +10,7 @@ import (
 
 var public bool
 var private bool
+var yes bool
 
 var Cmd = &cobra.Command{
 	Use:   "prep",
@@ -23,6 +24,7 @@ func init() {
 
 	Cmd.PersistentFlags().BoolVar(&public, "public", false, "manages public docker images when true")
 	Cmd.PersistentFlags().BoolVar(&private, "private", false, "manages private docker images when true")
+	Cmd.PersistentFlags().BoolVarP(&yes, "yes", "y", false, "skip the interactive confirmation before destroying")
 
 	viper.AutomaticEnv()
 	err := viper.BindPFlags(Cmd.Flags())
@@ -0,0 +1,100 @@
+package tidy
+
+import (
+	"context"
+	"github.com/spf13/cobra"
+	"github.com/stxkxs/ok-cli/logger"
+	"github.com/stxkxs/ok-cli/terminal"
+	"os"
+)
+
+var apply = &cobra.Command{
+	Use:   "apply <plan>",
+	Short: "apply a tidy plan",
+	Long:  `removes exactly the resources listed in a tidy plan, refusing to act if live resources drifted since planning`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Logger.Debug().
+			Strs("args", args).
+			Msg("ok tidy apply")
+
+		p, err := ReadPlan(args[0])
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Str("plan", args[0]).
+				Msg("error reading tidy plan")
+			os.Exit(exitFailure)
+		}
+
+		c := LoadTidyConf()
+		if c == nil {
+			os.Exit(exitFailure)
+		}
+
+		rep := NewReport(c.Prices)
+		live, err := NewPlan(c, rep)
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Msg("error planning tidy")
+			os.Exit(exitFailure)
+		}
+
+		gone, err := c.gone(p.missing(live))
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Msg("error checking planned resources")
+			os.Exit(exitFailure)
+		}
+
+		if drift := p.Drift(live, gone); len(drift) > 0 {
+			logger.Logger.Error().
+				Str("plan", args[0]).
+				Strs("drift", drift).
+				Msg("live resources drifted from tidy plan. re-plan before applying.")
+			os.Exit(exitFailure)
+		}
+
+		if !yes && terminal.Interactive() {
+			if err := p.confirm(terminal.NewPrompt(), environment, rep); err != nil {
+				logger.Logger.Error().
+					Err(err).
+					Msg("tidy apply cancelled")
+				os.Exit(exitFailure)
+			}
+		}
+
+		rep.journal = startJournal(p)
+		results := c.apply(interruptible(), p, rep)
+		closeJournal(rep)
+
+		summarize("tidy apply region summary", results)
+		writeReport(rep, results, reports)
+		exit("tidy apply finished with failures", results)
+
+		logger.Logger.Info().
+			Str("plan", args[0]).
+			Msg("applied tidy plan")
+	},
+}
+
+// apply deletes exactly the items of the plan, and marks its marks for
+// deletion once the grace period passed.
+func (c *Tidy) apply(ctx context.Context, p *Plan, rep *Report) []result {
+	return c.each(func(t target, s section) ([]string, error) {
+		return union(p.Accounts[t.account][s.name], p.Marks[t.account][s.name]), nil
+	}, func(t target, s section, region string) (int, error) {
+		r := s.reaper(region)
+		if err := s.mark(ctx, rep, t.account, r, region, p.Marks[t.account][s.name][region]); err != nil {
+			return 0, err
+		}
+
+		items := p.Accounts[t.account][s.name][region]
+		if len(items) == 0 {
+			return 0, nil
+		}
+		return len(items), s.delete(ctx, rep, t.account, r, region, items)
+	})
+}
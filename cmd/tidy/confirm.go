@@ -0,0 +1,132 @@
+package tidy
+
+import (
+	"fmt"
+	"github.com/stxkxs/ok-cli/logger"
+	"github.com/stxkxs/ok-cli/terminal"
+	"maps"
+	"os"
+	"slices"
+)
+
+// confirmed plans every section, asks the user to confirm the plan, and
+// deletes what the user kept. Sections that could not be listed are carried
+// into the results so they still count as failures.
+func (c *Tidy) confirmed(rep *Report) []result {
+	p, listed := c.plan(rep)
+
+	var results []result
+	for _, r := range listed {
+		if r.err != nil {
+			results = append(results, r)
+		}
+	}
+
+	if err := p.confirm(terminal.NewPrompt(), environment, rep); err != nil {
+		logger.Logger.Error().
+			Err(err).
+			Msg("tidy cancelled")
+		os.Exit(exitFailure)
+	}
+
+	rep.journal = startJournal(p)
+	return append(results, c.apply(interruptible(), p, rep)...)
+}
+
+// planned is a single plan entry, flattened for prompting. mark is set for
+// items to mark for deletion rather than delete.
+type planned struct {
+	section string
+	region  string
+	item    string
+	mark    bool
+}
+
+// confirm walks the user through the plan one account at a time: it lists
+// the planned resources, lets the user deselect some, and requires the
+// confirmation token before keeping the account in the plan. Deselected
+// items are recorded as skipped in rep.
+func (p *Plan) confirm(prompt *terminal.Prompt, environment string, rep *Report) error {
+	for _, account := range union(p.Accounts, p.Marks) {
+		var entries []planned
+		var labels []string
+
+		for _, mark := range []bool{false, true} {
+			sections := p.Accounts[account]
+			if mark {
+				sections = p.Marks[account]
+			}
+
+			for _, name := range slices.Sorted(maps.Keys(sections)) {
+				for _, region := range slices.Sorted(maps.Keys(sections[name])) {
+					for _, item := range sections[name][region] {
+						entries = append(entries, planned{section: name, region: region, item: item, mark: mark})
+
+						label := fmt.Sprintf("%-15s %-15s %s", name, region, item)
+						if mark {
+							label += " (mark for deletion)"
+						}
+						labels = append(labels, label)
+					}
+				}
+			}
+		}
+
+		if len(entries) == 0 {
+			continue
+		}
+
+		kept, err := prompt.Select(fmt.Sprintf("account %s: %d resources planned for deletion", account, len(entries)), labels)
+		if err != nil {
+			return err
+		}
+
+		selected, marks := make(map[string]Sections), make(map[string]Sections)
+		keep := make(map[int]bool, len(kept))
+		for _, i := range kept {
+			keep[i] = true
+		}
+
+		for i, e := range entries {
+			if !keep[i] {
+				rep.update(account, e.section, e.region, e.item, statusSkipped, "deselected")
+				continue
+			}
+
+			if e.mark {
+				add(marks, account, e.section, e.region, e.item)
+			} else {
+				add(selected, account, e.section, e.region, e.item)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(p.Accounts, account)
+			delete(p.Marks, account)
+			continue
+		}
+
+		question := fmt.Sprintf("delete %d resources in account %s?", len(kept), account)
+		if n := marks[account].count(); n > 0 {
+			question = fmt.Sprintf("delete %d and mark %d resources for deletion in account %s?", len(kept)-n, n, account)
+		}
+		if err := prompt.Confirm(question, terminal.Token(environment, account)); err != nil {
+			return err
+		}
+
+		setOrDelete(p.Accounts, account, selected[account])
+		if p.Marks != nil {
+			setOrDelete(p.Marks, account, marks[account])
+		}
+	}
+
+	return nil
+}
+
+func setOrDelete(accounts map[string]Sections, account string, sections Sections) {
+	if len(sections) == 0 {
+		delete(accounts, account)
+		return
+	}
+	accounts[account] = sections
+}
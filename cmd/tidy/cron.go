@@ -0,0 +1,178 @@
+package tidy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearch bounds how far ahead next looks for a matching minute, so an
+// expression that never matches, such as 0 0 31 2 *, cannot loop forever.
+const cronSearch = 5 * 366 * 24 * time.Hour
+
+// cron is a standard five field cron expression: minute, hour, day of month,
+// month, and day of week. Fields accept *, lists, ranges, and steps, e.g.
+// */15, 1-5, or 0,30. Like cron, a day matches when either the day of month
+// or the day of week matches if both are restricted, and when both match
+// otherwise. A field starting with *, such as */2, is not restricted.
+type cron struct {
+	expr   string
+	minute []bool
+	hour   []bool
+	dom    []bool
+	month  []bool
+	dow    []bool
+	anyDom bool
+	anyDow bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func parseCron(expr string) (*cron, error) {
+	fields := strings.Fields(expr)
+	if alias, ok := cronAliases[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		fields = strings.Fields(alias)
+	}
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q needs 5 fields, got %d", expr, len(fields))
+	}
+
+	c := &cron{expr: expr}
+
+	var err error
+	if c.minute, err = cronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("cron expression %q minute: %w", expr, err)
+	}
+	if c.hour, err = cronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("cron expression %q hour: %w", expr, err)
+	}
+	if c.dom, err = cronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("cron expression %q day of month: %w", expr, err)
+	}
+	if c.month, err = cronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("cron expression %q month: %w", expr, err)
+	}
+	if c.dow, err = cronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("cron expression %q day of week: %w", expr, err)
+	}
+
+	// 7 is sunday as well.
+	c.dow[0] = c.dow[0] || c.dow[7]
+	c.anyDom = strings.HasPrefix(fields[2], "*")
+	c.anyDow = strings.HasPrefix(fields[4], "*")
+
+	return c, nil
+}
+
+// cronField parses one field into a set indexed by value.
+func cronField(field string, lo, hi int) ([]bool, error) {
+	set := make([]bool, hi+1)
+
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, stepped := strings.Cut(part, "/")
+
+		step := 1
+		if stepped {
+			s, err := strconv.Atoi(stepText)
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step %q", stepText)
+			}
+			step = s
+		}
+
+		start, end := lo, hi
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+
+			var err error
+			if start, err = strconv.Atoi(from); err != nil {
+				return nil, fmt.Errorf("invalid value %q", from)
+			}
+
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid value %q", to)
+				}
+			} else if stepped {
+				end = hi
+			}
+		}
+
+		if start < lo || end > hi || start > end {
+			return nil, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+
+		for v := start; v <= end; v += step {
+			set[v] = true
+		}
+	}
+
+	return set, nil
+}
+
+// next returns the first minute after t matching the expression, or the
+// zero time when none matches within cronSearch. Times are matched by the
+// wall clock of t's location: a time skipped when clocks spring forward does
+// not match that day, and a time repeated when clocks fall back matches
+// once.
+func (c *cron) next(t time.Time) time.Time {
+	from := wall(t)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearch)
+
+	for t.Before(limit) {
+		switch {
+		case !c.month[t.Month()]:
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+		case !c.day(t):
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+		case !c.hour[t.Hour()]:
+			// minutes rather than a wall clock hour, which may not exist.
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case !c.minute[t.Minute()] || !wall(t).After(from):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// forward returns next, or the following minute when normalizing a wall
+// clock time that does not exist put next at or before t.
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+// wall is the wall clock minute of t, comparable across a fall back.
+func wall(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func (c *cron) day(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cron) String() string {
+	return c.expr
+}
@@ -0,0 +1,172 @@
+package tidy
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		err  bool
+	}{
+		{expr: "* * * * *"},
+		{expr: "*/15 1-5 1,15 */3 1-5"},
+		{expr: "0 0 * * 7"},
+		{expr: "@daily"},
+		{expr: " @Hourly "},
+		{expr: "0 0 * *", err: true},
+		{expr: "0 0 * * * *", err: true},
+		{expr: "60 * * * *", err: true},
+		{expr: "* 24 * * *", err: true},
+		{expr: "* * 0 * *", err: true},
+		{expr: "* * * 13 *", err: true},
+		{expr: "* * * * 8", err: true},
+		{expr: "5-1 * * * *", err: true},
+		{expr: "*/0 * * * *", err: true},
+		{expr: "a * * * *", err: true},
+		{expr: "1-a * * * *", err: true},
+		{expr: "@fortnightly", err: true},
+	} {
+		_, err := parseCron(tc.expr)
+		if (err != nil) != tc.err {
+			t.Errorf("parseCron(%q) error %v, want error %v", tc.expr, err, tc.err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	for _, tc := range []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			from: utc("2026-01-01T10:07:30Z"),
+			want: utc("2026-01-01T10:08:00Z"),
+		},
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			from: utc("2026-01-01T10:07:00Z"),
+			want: utc("2026-01-01T10:15:00Z"),
+		},
+		{
+			name: "strictly after",
+			expr: "*/15 * * * *",
+			from: utc("2026-01-01T10:15:00Z"),
+			want: utc("2026-01-01T10:30:00Z"),
+		},
+		{
+			name: "next day",
+			expr: "0 3 * * *",
+			from: utc("2026-01-01T03:00:00Z"),
+			want: utc("2026-01-02T03:00:00Z"),
+		},
+		{
+			name: "range step",
+			expr: "10-40/15 * * * *",
+			from: utc("2026-01-01T10:26:00Z"),
+			want: utc("2026-01-01T10:40:00Z"),
+		},
+		{
+			name: "next year",
+			expr: "0 0 1 1 *",
+			from: utc("2026-06-01T00:00:00Z"),
+			want: utc("2027-01-01T00:00:00Z"),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			from: utc("2026-03-01T00:00:00Z"),
+			want: utc("2028-02-29T00:00:00Z"),
+		},
+		{
+			name: "never",
+			expr: "0 0 31 2 *",
+			from: utc("2026-01-01T00:00:00Z"),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 9 * * 7",
+			from: utc("2026-01-01T00:00:00Z"),
+			want: utc("2026-01-04T09:00:00Z"),
+		},
+		{
+			name: "day of week only",
+			expr: "0 0 * * 1",
+			from: utc("2026-01-01T00:00:00Z"),
+			want: utc("2026-01-05T00:00:00Z"),
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 3 * 1",
+			from: utc("2026-01-01T00:00:00Z"),
+			want: utc("2026-01-03T00:00:00Z"),
+		},
+		{
+			name: "starred day of month step and day of week",
+			expr: "0 0 */2 * 1",
+			from: utc("2026-01-01T00:00:00Z"),
+			want: utc("2026-01-05T00:00:00Z"),
+		},
+		{
+			name: "day of month and starred day of week step",
+			expr: "0 0 10 * */3",
+			from: utc("2026-01-01T00:00:00Z"),
+			want: utc("2026-01-10T00:00:00Z"),
+		},
+		{
+			name: "spring forward skips the missing hour",
+			expr: "30 2 * * *",
+			from: time.Date(2026, 3, 8, 0, 0, 0, 0, ny),
+			want: time.Date(2026, 3, 9, 2, 30, 0, 0, ny),
+		},
+		{
+			name: "spring forward hourly",
+			expr: "0 * * * *",
+			from: time.Date(2026, 3, 8, 1, 0, 0, 0, ny),
+			want: time.Date(2026, 3, 8, 3, 0, 0, 0, ny),
+		},
+		{
+			name: "fall back runs the repeated hour once",
+			expr: "30 1 * * *",
+			from: time.Date(2026, 11, 1, 1, 30, 0, 0, ny),
+			want: time.Date(2026, 11, 2, 1, 30, 0, 0, ny),
+		},
+		{
+			name: "fall back hourly",
+			expr: "0 * * * *",
+			from: time.Date(2026, 11, 1, 0, 30, 0, 0, ny),
+			want: time.Date(2026, 11, 1, 1, 0, 0, 0, ny),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := parseCron(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := c.next(tc.from); !got.Equal(tc.want) {
+				t.Errorf("next(%s) of %q = %s, want %s", tc.from, tc.expr, got, tc.want)
+			}
+		})
+	}
+}
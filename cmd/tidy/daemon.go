@@ -0,0 +1,268 @@
+package tidy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/stxkxs/ok-cli/aws"
+	"github.com/stxkxs/ok-cli/logger"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultListen = "127.0.0.1:9464"
+
+// Daemon schedules tidy sections in-process. Schedule maps a section name
+// to a cron expression, Listen is the address of the health and metrics
+// endpoints, and Lock the lock file that keeps a second daemon from
+// starting.
+type Daemon struct {
+	Listen   string            `mapstructure:"listen"`
+	Lock     string            `mapstructure:"lock"`
+	Schedule map[string]string `mapstructure:"schedule"`
+}
+
+var daemon = &cobra.Command{
+	Use:   "daemon",
+	Short: "run tidy sections on a schedule",
+	Long:  `runs each tidy section on the cron schedule in .ok.tidy, serving /healthz and prometheus /metrics until interrupted`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Logger.Debug().
+			Strs("args", args).
+			Msg("ok tidy daemon")
+
+		c := LoadTidyConf()
+		if c == nil {
+			os.Exit(exitFailure)
+		}
+
+		if err := c.daemon(); err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Msg("error running tidy daemon")
+			os.Exit(exitFailure)
+		}
+	},
+}
+
+func (c *Tidy) daemon() error {
+	schedules, err := c.schedules()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lock(c.Daemon.Lock)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ctx, stop := context.WithCancel(interruptible())
+	defer stop()
+
+	m := newMetrics(schedules)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", m.health)
+	mux.HandleFunc("/metrics", m.prometheus)
+
+	server := &http.Server{Addr: cmp.Or(c.Daemon.Listen, defaultListen), Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	logger.Logger.Info().
+		Str("listen", server.Addr).
+		Interface("schedule", c.Daemon.Schedule).
+		Msg("started tidy daemon")
+
+	var wg sync.WaitGroup
+	for name, schedule := range schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.scheduled(ctx, name, schedule, m)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+	case err = <-served:
+		logger.Logger.Error().
+			Err(err).
+			Str("listen", server.Addr).
+			Msg("tidy daemon endpoint stopped")
+		stop()
+	}
+
+	wg.Wait()
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdown)
+
+	logger.Logger.Info().Msg("stopped tidy daemon")
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// schedules parses the cron expression of every scheduled section.
+func (c *Tidy) schedules() (map[string]*cron, error) {
+	if len(c.Daemon.Schedule) == 0 {
+		return nil, fmt.Errorf("no tidy sections are scheduled, set daemon.schedule in .ok.tidy")
+	}
+
+	schedules := make(map[string]*cron, len(c.Daemon.Schedule))
+	for name, expr := range c.Daemon.Schedule {
+		if _, ok := c.Sections[name]; !ok {
+			return nil, fmt.Errorf("scheduled tidy section %s is not configured", name)
+		}
+
+		schedule, err := parseCron(expr)
+		if err != nil {
+			return nil, fmt.Errorf("tidy section %s: %w", name, err)
+		}
+		schedules[name] = schedule
+	}
+
+	return schedules, nil
+}
+
+// scheduled runs one section every time its schedule fires until ctx is
+// cancelled. Runs of a section never overlap, a run that outlasts its
+// interval delays the next one.
+func (c *Tidy) scheduled(ctx context.Context, name string, schedule *cron, m *metrics) {
+	only := *c
+	only.Sections = map[string]aws.ReaperConfig{name: c.Sections[name]}
+
+	for {
+		next := schedule.next(time.Now())
+		if next.IsZero() {
+			logger.Logger.Error().
+				Str("section", name).
+				Str("schedule", schedule.String()).
+				Msg("tidy schedule never fires")
+			return
+		}
+		m.scheduled(name, next)
+
+		logger.Logger.Info().
+			Str("section", name).
+			Time("next", next).
+			Msg("scheduled tidy run")
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		m.running(name)
+
+		rep := NewReport(c.Prices)
+		if len(reports) == 0 {
+			rep.countOnly()
+		}
+		results := only.each(resolved, func(t target, s section, region string) (int, error) {
+			return s.stream(ctx, rep, t.account, s.reaper(region), region)
+		})
+
+		summarize("tidy scheduled region summary", results)
+		writeReport(rep, results, sectionReports(name))
+		m.finished(name, time.Now(), rep.totals(), exitCode(results))
+
+		if lines := failures(results); len(lines) > 0 {
+			logger.Logger.Error().
+				Str("section", name).
+				Strs("failures", lines).
+				Msg("scheduled tidy run finished with failures")
+		}
+	}
+}
+
+// sectionReports names the report files of one section, tidy.json becomes
+// tidy.cloudwatch.json, so sections running at once never write the same
+// file.
+func sectionReports(name string) []string {
+	paths := make([]string, 0, len(reports))
+	for _, path := range reports {
+		ext := filepath.Ext(path)
+		paths = append(paths, strings.TrimSuffix(path, ext)+"."+name+ext)
+	}
+	return paths
+}
+
+// lock creates the daemon lock file holding the pid of this process. A lock
+// left by a process that is no longer running is taken over.
+func lock(path string) (func(), error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, ".ok", "state", "tidy-daemon.lock")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+
+			return func() { os.Remove(path) }, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil && alive(pid) {
+			return nil, fmt.Errorf("tidy daemon already running with pid %d, lock %s", pid, path)
+		}
+
+		logger.Logger.Warn().
+			Str("lock", path).
+			Msg("removing stale tidy daemon lock")
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("could not acquire tidy daemon lock %s", path)
+}
+
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
@@ -0,0 +1,279 @@
+package tidy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stxkxs/ok-cli/logger"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	journalStarted  = "started"
+	journalResumed  = "resumed"
+	journalFinished = "finished"
+
+	// journalStreamed marks runs that list as they go rather than delete a
+	// plan, so resuming them lists everything the run had not reached yet.
+	journalStreamed = "streamed"
+
+	// statusDeleting marks items handed to a reaper for deletion. It only
+	// appears in the journal, reports record the outcome instead.
+	statusDeleting = "deleting"
+)
+
+// Journal records every planned, in flight, and completed deletion of a tidy
+// run under ~/.ok/state, one json line per change, so that an interrupted
+// run can be resumed. A nil journal records nothing.
+type Journal struct {
+	path string
+
+	mu     sync.Mutex
+	f      *os.File
+	failed bool
+}
+
+type entry struct {
+	Time    time.Time `json:"time"`
+	Status  string    `json:"status"`
+	Account string    `json:"account,omitempty"`
+	Section string    `json:"section,omitempty"`
+	Region  string    `json:"region,omitempty"`
+	Item    string    `json:"item,omitempty"`
+}
+
+// journalPath keeps one journal per environment, so runs against different
+// environments never resume each other.
+func journalPath(environment string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ok", "state", fmt.Sprintf("tidy-%s.ndjson", cmp.Or(environment, "default"))), nil
+}
+
+// NewJournal starts the journal of a new run, replacing the journal of the
+// previous run.
+func NewJournal(environment string) (*Journal, error) {
+	path, err := journalPath(environment)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path, f: f}
+	j.record(journalStarted, "", "", "")
+
+	return j, nil
+}
+
+// OpenJournal reopens the journal of the previous run to continue it and
+// returns the entries recorded so far.
+func OpenJournal(environment string) (*Journal, []entry, error) {
+	path, err := journalPath(environment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// end a line torn by a killed run, so it does not swallow the next entry.
+	if _, err := f.WriteString("\n"); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	j := &Journal{path: path, f: f}
+	j.record(journalResumed, "", "", "")
+
+	return j, entries, nil
+}
+
+// readJournal reads every entry of a journal. A torn last line, left by a
+// run killed mid write, is ignored.
+func readJournal(path string) ([]entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []entry
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			logger.Logger.Warn().
+				Err(err).
+				Str("journal", path).
+				Msg("skipping unreadable tidy journal entry")
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// record appends one entry per item. A journal that cannot be written is
+// logged once and otherwise ignored, it never stops a run.
+func (j *Journal) record(status, account, section, region string, items ...string) {
+	if j == nil {
+		return
+	}
+
+	if len(items) == 0 {
+		items = []string{""}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	var errs []error
+	for _, item := range items {
+		b, err := json.Marshal(entry{Time: now, Status: status, Account: account, Section: section, Region: region, Item: item})
+		if err == nil {
+			_, err = j.f.Write(append(b, '\n'))
+		}
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil && !j.failed {
+		j.failed = true
+		logger.Logger.Error().
+			Err(err).
+			Str("journal", j.path).
+			Msg("error writing tidy journal. the run cannot be resumed.")
+	}
+}
+
+// finish marks that the run ended on its own rather than being interrupted.
+func (j *Journal) finish() {
+	j.record(journalFinished, "", "", "")
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// startJournal starts the journal of a new run and records the items of p,
+// if any, as planned. tidy runs without a journal when it cannot be created.
+func startJournal(p *Plan) *Journal {
+	j, err := NewJournal(environment)
+	if err != nil {
+		logger.Logger.Warn().
+			Err(err).
+			Msg("error creating tidy journal. the run cannot be resumed.")
+		return nil
+	}
+
+	if p == nil {
+		j.record(journalStreamed, "", "", "")
+	} else {
+		for account, sections := range p.Accounts {
+			for name, section := range sections {
+				for region, items := range section {
+					j.record(statusPlanned, account, name, region, items...)
+				}
+			}
+		}
+	}
+
+	return j
+}
+
+// closeJournal marks the run finished unless it was interrupted.
+func closeJournal(rep *Report) {
+	if !interrupted.Load() {
+		rep.journal.finish()
+	}
+
+	if err := rep.journal.Close(); err != nil {
+		logger.Logger.Warn().
+			Err(err).
+			Msg("error closing tidy journal")
+	}
+}
+
+// unfinished folds the journal entries into the work the run left undone:
+// items planned or failed go in todo, and items left deleting, which may
+// still be deleting, go in deleting. finished reports whether the run ended
+// on its own, and streamed whether it was a streamed run.
+func unfinished(entries []entry) (todo, deleting *Plan, finished, streamed bool) {
+	type key struct{ account, section, region, item string }
+
+	var keys []key
+	last := make(map[key]string)
+	for _, e := range entries {
+		switch e.Status {
+		case journalStarted, journalResumed:
+			finished = false
+			continue
+		case journalFinished:
+			finished = true
+			continue
+		case journalStreamed:
+			streamed = true
+			continue
+		}
+
+		k := key{e.Account, e.Section, e.Region, e.Item}
+		if _, ok := last[k]; !ok {
+			keys = append(keys, k)
+		}
+		last[k] = e.Status
+	}
+
+	todo = &Plan{Created: time.Now().UTC(), Accounts: make(map[string]Sections)}
+	deleting = &Plan{Created: time.Now().UTC(), Accounts: make(map[string]Sections)}
+
+	for _, k := range keys {
+		var p *Plan
+		switch last[k] {
+		case statusPlanned, statusFailed:
+			p = todo
+		case statusDeleting:
+			p = deleting
+		default:
+			continue
+		}
+
+		p.add(k.account, k.section, k.region, k.item)
+	}
+
+	return todo, deleting, finished, streamed
+}
@@ -6,20 +6,34 @@ import (
 	"github.com/stxkxs/ok-cli/aws"
 	"github.com/stxkxs/ok-cli/env"
 	"github.com/stxkxs/ok-cli/logger"
+	"github.com/stxkxs/ok-cli/terminal"
+	"os"
 )
 
+// Tidy holds the settings shared by every section. Sections are decoded
+// separately, one per registered reaper configured in .ok.tidy.
 type Tidy struct {
-	CodeBuild      aws.CodeBuild      `mapstructure:"codebuild"`
-	CloudWatch     aws.CloudWatch     `mapstructure:"cloudwatch"`
-	CloudFormation aws.CloudFormation `mapstructure:"cloudformation"`
+	Accounts    []aws.Account               `mapstructure:"accounts"`
+	Tags        string                      `mapstructure:"tags"`
+	Protect     aws.Protect                 `mapstructure:"protect"`
+	Grace       aws.Grace                   `mapstructure:"grace"`
+	Parallelism int                         `mapstructure:"parallelism"`
+	Prices      map[string]float64          `mapstructure:"prices"`
+	RateLimits  map[string]aws.RateLimit    `mapstructure:"rateLimits"`
+	Daemon      Daemon                      `mapstructure:"daemon"`
+	Sections    map[string]aws.ReaperConfig `mapstructure:"-"`
 }
 
 var file string
+var environment string
+var reports []string
+var yes bool
+var resume bool
 
 var Cmd = &cobra.Command{
 	Use:   "tidy",
 	Short: "aws resource cleanup",
-	Long:  `removes codebuild build history, cloudwatch log groups, and cloudformation stacks`,
+	Long:  `removes codebuild build history, cloudwatch log groups, cloudformation stacks, and ecr images`,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		var err error
 
@@ -30,6 +44,14 @@ var Cmd = &cobra.Command{
 				Msg("error fetching file flag")
 			return
 		}
+
+		environment, err = cmd.Flags().GetString("environment")
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Msg("error fetching environment flag")
+			return
+		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Logger.Debug().
@@ -37,30 +59,33 @@ var Cmd = &cobra.Command{
 			Msg("ok tidy")
 
 		c := LoadTidyConf()
-
-		err := aws.DestroyBuildHistory(c.CodeBuild)
-		if err != nil {
-			logger.Logger.Error().
-				Err(err).
-				Msg("error destroying codebuild history")
-			return
+		if c == nil {
+			os.Exit(exitFailure)
 		}
 
-		err = aws.DestroyLogGroups(c.CloudWatch)
-		if err != nil {
-			logger.Logger.Error().
-				Err(err).
-				Msg("error destroying cloudwatch logs")
-			return
-		}
+		rep := NewReport(c.Prices)
 
-		err = aws.DestroyStacks(c.CloudFormation)
-		if err != nil {
-			logger.Logger.Error().
-				Err(err).
-				Msg("error destroying cloudformation stacks")
-			return
+		var results []result
+		switch {
+		case resume:
+			results = c.resumed(rep)
+		case !yes && terminal.Interactive():
+			results = c.confirmed(rep)
+		default:
+			if len(reports) == 0 {
+				rep.countOnly()
+			}
+			rep.journal = startJournal(nil)
+			ctx := interruptible()
+			results = c.each(resolved, func(t target, s section, region string) (int, error) {
+				return s.stream(ctx, rep, t.account, s.reaper(region), region)
+			})
 		}
+		closeJournal(rep)
+
+		summarize("tidy region summary", results)
+		writeReport(rep, results, reports)
+		exit("tidy finished with failures", results)
 	},
 }
 
@@ -73,6 +98,45 @@ func LoadTidyConf() *Tidy {
 		return nil
 	}
 
+	if c.Tags != "" {
+		if _, err := aws.ParseTagSelector(c.Tags); err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Str("tags", c.Tags).
+				Msg("error parsing tidy tag selector")
+			return nil
+		}
+	}
+
+	aws.SetRateLimits(c.RateLimits)
+
+	c.Sections = make(map[string]aws.ReaperConfig)
+	for _, r := range reapers {
+		if !viper.IsSet(r.name) {
+			continue
+		}
+
+		s := r.config()
+		if err := viper.UnmarshalKey(r.name, s); err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Str("section", r.name).
+				Msg("error decoding tidy section")
+			return nil
+		}
+
+		s.Defaults(c.Tags, c.Protect, c.Grace)
+		if err := s.Validate(); err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Str("section", r.name).
+				Msg("error validating tidy section")
+			return nil
+		}
+
+		c.Sections[r.name] = s
+	}
+
 	logger.Logger.Debug().
 		Interface("decoded", c).
 		Msg("decoded tidy conf")
@@ -80,7 +144,31 @@ func LoadTidyConf() *Tidy {
 	return &c
 }
 
+// writeReport writes the report to every --report path. A report that
+// cannot be written is logged but does not change the exit code.
+func writeReport(rep *Report, results []result, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+
+	rep.failed(results)
+	if err := rep.Write(paths); err != nil {
+		logger.Logger.Error().
+			Err(err).
+			Msg("error writing tidy report")
+	}
+}
+
 func init() {
+	Cmd.AddCommand(plan)
+	Cmd.AddCommand(apply)
+	Cmd.AddCommand(daemon)
+	Cmd.AddCommand(restore)
+
+	Cmd.PersistentFlags().StringSliceVar(&reports, "report", nil, "write a tidy report, formatted as json, markdown, or csv by the file extension")
+	Cmd.PersistentFlags().BoolVarP(&yes, "yes", "y", false, "skip the interactive confirmation")
+	Cmd.Flags().BoolVar(&resume, "resume", false, "resume the previous tidy run from its journal under ~/.ok/state")
+
 	err := viper.BindPFlags(Cmd.Flags())
 	if err != nil {
 		logger.Logger.Error().
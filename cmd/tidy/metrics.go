@@ -0,0 +1,168 @@
+package tidy
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stxkxs/ok-cli/aws"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	runSuccess = "success"
+	runPartial = "partial"
+	runFailure = "failure"
+)
+
+// metrics tracks the scheduled runs of every section for the health and
+// prometheus endpoints of the daemon.
+type metrics struct {
+	mu       sync.Mutex
+	started  time.Time
+	sections map[string]*sectionMetrics
+}
+
+type sectionMetrics struct {
+	Schedule    string         `json:"schedule"`
+	Running     bool           `json:"running"`
+	Next        time.Time      `json:"next"`
+	LastRun     time.Time      `json:"lastRun,omitzero"`
+	LastSuccess time.Time      `json:"lastSuccess,omitzero"`
+	LastResult  string         `json:"lastResult,omitempty"`
+	Deleted     int            `json:"deleted"`
+	Failed      int            `json:"failed"`
+	Runs        map[string]int `json:"runs"`
+}
+
+func newMetrics(schedules map[string]*cron) *metrics {
+	m := &metrics{started: time.Now(), sections: make(map[string]*sectionMetrics)}
+	for name, c := range schedules {
+		m.sections[name] = &sectionMetrics{Schedule: c.String(), Runs: make(map[string]int)}
+	}
+	return m
+}
+
+func (m *metrics) scheduled(section string, next time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sections[section].Next = next
+}
+
+func (m *metrics) running(section string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sections[section].Running = true
+}
+
+// finished records the outcome of a run. A run without failures is a
+// success, one where only some work failed is partial.
+func (m *metrics) finished(section string, at time.Time, t Totals, code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.sections[section]
+	s.Running = false
+	s.LastRun = at
+	s.Deleted += t.Deleted
+	s.Failed += t.Failed
+
+	switch code {
+	case 0:
+		s.LastResult = runSuccess
+		s.LastSuccess = at
+	case exitPartial:
+		s.LastResult = runPartial
+	default:
+		s.LastResult = runFailure
+	}
+	s.Runs[s.LastResult]++
+}
+
+// health reports every section along with its schedule and last run.
+func (m *metrics) health(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	b, err := json.MarshalIndent(struct {
+		Status   string                     `json:"status"`
+		Started  time.Time                  `json:"started"`
+		Sections map[string]*sectionMetrics `json:"sections"`
+	}{"ok", m.started, m.sections}, "", "  ")
+	m.mu.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// prometheus writes the metrics in the prometheus text exposition format.
+func (m *metrics) prometheus(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	names := slices.Sorted(maps.Keys(m.sections))
+
+	family := func(name, kind, help string, sample func(section string, s *sectionMetrics)) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, section := range names {
+			sample(section, m.sections[section])
+		}
+	}
+
+	family("ok_tidy_items_deleted_total", "counter", "Items deleted by scheduled tidy runs.", func(section string, s *sectionMetrics) {
+		fmt.Fprintf(&b, "ok_tidy_items_deleted_total{section=%q} %d\n", section, s.Deleted)
+	})
+
+	family("ok_tidy_items_failed_total", "counter", "Items scheduled tidy runs failed to delete.", func(section string, s *sectionMetrics) {
+		fmt.Fprintf(&b, "ok_tidy_items_failed_total{section=%q} %d\n", section, s.Failed)
+	})
+
+	family("ok_tidy_runs_total", "counter", "Scheduled tidy runs by result.", func(section string, s *sectionMetrics) {
+		for _, result := range []string{runSuccess, runPartial, runFailure} {
+			fmt.Fprintf(&b, "ok_tidy_runs_total{section=%q,result=%q} %d\n", section, result, s.Runs[result])
+		}
+	})
+
+	family("ok_tidy_running", "gauge", "Whether a tidy run of the section is in progress.", func(section string, s *sectionMetrics) {
+		running := 0
+		if s.Running {
+			running = 1
+		}
+		fmt.Fprintf(&b, "ok_tidy_running{section=%q} %d\n", section, running)
+	})
+
+	family("ok_tidy_last_success_timestamp_seconds", "gauge", "Unix time of the last successful tidy run, 0 before the first.", func(section string, s *sectionMetrics) {
+		fmt.Fprintf(&b, "ok_tidy_last_success_timestamp_seconds{section=%q} %d\n", section, unix(s.LastSuccess))
+	})
+
+	family("ok_tidy_last_run_timestamp_seconds", "gauge", "Unix time the last tidy run finished, 0 before the first.", func(section string, s *sectionMetrics) {
+		fmt.Fprintf(&b, "ok_tidy_last_run_timestamp_seconds{section=%q} %d\n", section, unix(s.LastRun))
+	})
+
+	family("ok_tidy_next_run_timestamp_seconds", "gauge", "Unix time of the next scheduled tidy run.", func(section string, s *sectionMetrics) {
+		fmt.Fprintf(&b, "ok_tidy_next_run_timestamp_seconds{section=%q} %d\n", section, unix(s.Next))
+	})
+
+	throttles := aws.Throttles()
+	fmt.Fprintf(&b, "# HELP ok_tidy_throttled_requests_total AWS requests throttled, by service.\n# TYPE ok_tidy_throttled_requests_total counter\n")
+	for _, service := range slices.Sorted(maps.Keys(throttles)) {
+		fmt.Fprintf(&b, "ok_tidy_throttled_requests_total{service=%q} %d\n", service, throttles[service])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
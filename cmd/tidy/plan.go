@@ -0,0 +1,312 @@
+package tidy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/stxkxs/ok-cli/aws"
+	"github.com/stxkxs/ok-cli/logger"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultPlan = "tidy.plan.json"
+
+// Plan lists the items to delete by account, and the items to mark for
+// deletion once the grace period passed.
+type Plan struct {
+	Created  time.Time           `json:"created"`
+	Accounts map[string]Sections `json:"accounts"`
+	Marks    map[string]Sections `json:"marks,omitempty"`
+}
+
+// Sections maps each tidy section to its planned items.
+type Sections map[string]Section
+
+// Section maps each region to the items planned for deletion there.
+type Section map[string][]string
+
+var plan = &cobra.Command{
+	Use:   "plan [plan]",
+	Short: "preview aws resource cleanup",
+	Long:  `lists every resource each tidy section would remove and writes them to a plan file`,
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Logger.Debug().
+			Strs("args", args).
+			Msg("ok tidy plan")
+
+		out := defaultPlan
+		if len(args) > 0 {
+			out = args[0]
+		}
+
+		c := LoadTidyConf()
+		if c == nil {
+			os.Exit(exitFailure)
+		}
+
+		rep := NewReport(c.Prices)
+		p, err := NewPlan(c, rep)
+		writeReport(rep, nil, reports)
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Msg("error planning tidy")
+			os.Exit(exitFailure)
+		}
+
+		err = p.Write(out)
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Str("plan", out).
+				Msg("error writing tidy plan")
+			os.Exit(exitFailure)
+		}
+
+		e := logger.Logger.Info().Str("plan", out)
+		for _, account := range slices.Sorted(maps.Keys(p.Accounts)) {
+			for _, name := range slices.Sorted(maps.Keys(p.Accounts[account])) {
+				e = e.Int(account+"."+name, p.Accounts[account][name].count())
+			}
+		}
+		e.Msg("wrote tidy plan")
+	},
+}
+
+// NewPlan lists the candidates of every section and records them in rep.
+// It fails when any section could not be listed.
+func NewPlan(c *Tidy, rep *Report) (*Plan, error) {
+	p, results := c.plan(rep)
+	if err := errs(results); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// plan lists the candidates of every section it can and returns the results
+// of listing alongside the plan.
+// Planning never marks resources for a grace period, it records them in the
+// marks of the plan instead.
+func (c *Tidy) plan(rep *Report) (*Plan, []result) {
+	p := &Plan{
+		Created:  time.Now().UTC(),
+		Accounts: make(map[string]Sections),
+	}
+
+	var mu sync.Mutex
+	results := c.each(resolved, func(t target, s section, region string) (int, error) {
+		ctx := aws.WithPending(context.Background(), func(item string) {
+			mu.Lock()
+			defer mu.Unlock()
+			p.mark(t.account, s.name, region, item)
+		})
+
+		items, err := s.list(ctx, rep, t.account, s.reaper(region), region)
+		if err != nil {
+			return 0, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		p.add(t.account, s.name, region, items...)
+
+		return len(items), nil
+	})
+
+	summarize("tidy plan region summary", results)
+	rep.failed(results)
+
+	return p, results
+}
+
+func ReadPlan(path string) (*Plan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Plan
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (p *Plan) Write(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0o644)
+}
+
+// gone returns the items of missing, planned items the live plan no longer
+// lists, that no longer exist in aws. The others are still there but no
+// longer selected, e.g. since the configuration changed.
+func (c *Tidy) gone(missing *Plan) (*Plan, error) {
+	p := &Plan{
+		Created:  time.Now().UTC(),
+		Accounts: make(map[string]Sections),
+	}
+	if missing.count() == 0 {
+		return p, nil
+	}
+
+	var mu sync.Mutex
+	results := c.each(func(t target, s section) ([]string, error) {
+		return union(missing.Accounts[t.account][s.name], nil), nil
+	}, func(t target, s section, region string) (int, error) {
+		items := missing.Accounts[t.account][s.name][region]
+
+		gone, err := aws.Gone(context.Background(), s.reaper(region), items)
+		if err != nil {
+			return len(items), err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		p.add(t.account, s.name, region, gone...)
+
+		return len(items), nil
+	})
+
+	if err := errs(results); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// missing returns the planned items the live plan no longer lists.
+func (p *Plan) missing(live *Plan) *Plan {
+	m := &Plan{
+		Created:  live.Created,
+		Accounts: make(map[string]Sections),
+	}
+
+	for account, sections := range p.Accounts {
+		for name, regions := range sections {
+			for region, items := range regions {
+				is := set(live.Accounts[account][name][region])
+				for _, item := range items {
+					if !is[item] {
+						m.add(account, name, region, item)
+					}
+				}
+			}
+		}
+	}
+
+	return m
+}
+
+// Drift compares a previously written plan against the live plan and
+// describes every item that was added or removed since planning. Removed
+// items in gone no longer exist, the others were dropped from the plan.
+func (p *Plan) Drift(live, gone *Plan) []string {
+	var drift []string
+
+	for _, account := range union(p.Accounts, live.Accounts) {
+		planned, current := p.Accounts[account], live.Accounts[account]
+
+		for _, name := range union(planned, current) {
+			for _, region := range union(planned[name], current[name]) {
+				was, is := set(planned[name][region]), set(current[name][region])
+				deleted := set(gone.Accounts[account][name][region])
+
+				for _, item := range planned[name][region] {
+					switch {
+					case is[item]:
+					case deleted[item]:
+						drift = append(drift, fmt.Sprintf("%s %s %s %s no longer exists", account, name, region, item))
+					default:
+						drift = append(drift, fmt.Sprintf("%s %s %s %s was dropped from the plan, no longer selected", account, name, region, item))
+					}
+				}
+
+				for _, item := range current[name][region] {
+					if !was[item] {
+						drift = append(drift, fmt.Sprintf("%s %s %s %s is not in the plan", account, name, region, item))
+					}
+				}
+			}
+		}
+	}
+
+	return drift
+}
+
+func set(items []string) map[string]bool {
+	s := make(map[string]bool, len(items))
+	for _, item := range items {
+		s[item] = true
+	}
+	return s
+}
+
+func union[M ~map[string]V, V any](a, b M) []string {
+	keys := slices.Collect(maps.Keys(a))
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (p *Plan) add(account, section, region string, items ...string) {
+	add(p.Accounts, account, section, region, items...)
+}
+
+func (p *Plan) mark(account, section, region string, items ...string) {
+	if p.Marks == nil {
+		p.Marks = make(map[string]Sections)
+	}
+	add(p.Marks, account, section, region, items...)
+}
+
+func add(accounts map[string]Sections, account, section, region string, items ...string) {
+	if accounts[account] == nil {
+		accounts[account] = make(Sections)
+	}
+	if accounts[account][section] == nil {
+		accounts[account][section] = make(Section)
+	}
+	accounts[account][section][region] = append(accounts[account][section][region], items...)
+}
+
+func (p *Plan) count() int {
+	n := 0
+	for _, sections := range p.Accounts {
+		n += sections.count()
+	}
+	return n
+}
+
+func (s Sections) count() int {
+	n := 0
+	for _, section := range s {
+		n += section.count()
+	}
+	return n
+}
+
+func (s Section) count() int {
+	n := 0
+	for _, items := range s {
+		n += len(items)
+	}
+	return n
+}
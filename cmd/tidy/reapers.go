@@ -0,0 +1,26 @@
+package tidy
+
+import (
+	"github.com/stxkxs/ok-cli/aws"
+)
+
+// reaper registers a tidy section. config returns an empty config section
+// that the section of the same name in .ok.tidy is decoded into.
+type reaper struct {
+	name   string
+	config func() aws.ReaperConfig
+}
+
+var reapers []reaper
+
+// Register adds a resource type to tidy. Sections run in registration order.
+func Register(name string, config func() aws.ReaperConfig) {
+	reapers = append(reapers, reaper{name: name, config: config})
+}
+
+func init() {
+	Register("codebuild", func() aws.ReaperConfig { return &aws.CodeBuild{} })
+	Register("cloudwatch", func() aws.ReaperConfig { return &aws.CloudWatch{} })
+	Register("cloudformation", func() aws.ReaperConfig { return &aws.CloudFormation{} })
+	Register("ecr", func() aws.ReaperConfig { return &aws.ECR{} })
+}
@@ -0,0 +1,387 @@
+package tidy
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stxkxs/ok-cli/aws"
+	"github.com/stxkxs/ok-cli/logger"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	statusPlanned = "planned"
+	statusDeleted = "deleted"
+	statusSkipped = "skipped"
+	statusFailed  = "failed"
+)
+
+const gib = 1 << 30
+
+// defaultPrices are the monthly storage prices in usd per gib used to
+// estimate savings, keyed by section. The prices table in .ok.tidy
+// overrides them.
+var defaultPrices = map[string]float64{
+	"cloudwatch": 0.03,
+	"ecr":        0.10,
+}
+
+// Report lists every resource tidy considered and what became of it.
+type Report struct {
+	Created   time.Time        `json:"created"`
+	Totals    Totals           `json:"totals"`
+	Throttles map[string]int64 `json:"throttles,omitempty"`
+	Resources []Resource       `json:"resources"`
+
+	prices  map[string]float64
+	index   map[string]int
+	journal *Journal
+	mu      sync.Mutex
+
+	// counting is set when only totals are kept. open holds the planned
+	// items until their deletion ends and counted the totals of every item
+	// that reached its final status.
+	counting bool
+	open     map[string]Resource
+	counted  Totals
+}
+
+type Totals struct {
+	Considered     int     `json:"considered"`
+	Planned        int     `json:"planned"`
+	Deleted        int     `json:"deleted"`
+	Skipped        int     `json:"skipped"`
+	Failed         int     `json:"failed"`
+	BytesPlanned   int64   `json:"bytesPlanned"`
+	BytesReclaimed int64   `json:"bytesReclaimed"`
+	PlannedSavings float64 `json:"plannedSavings"`
+	MonthlySavings float64 `json:"monthlySavings"`
+}
+
+// Resource is a single item along with its status. Item is empty when a
+// whole account, section, or region failed before any item was listed.
+type Resource struct {
+	Account        string  `json:"account"`
+	Section        string  `json:"section"`
+	Region         string  `json:"region"`
+	Item           string  `json:"item"`
+	Description    string  `json:"description,omitempty"`
+	Status         string  `json:"status"`
+	Reason         string  `json:"reason,omitempty"`
+	Previous       string  `json:"previous,omitempty"`
+	Bytes          int64   `json:"bytes,omitempty"`
+	MonthlySavings float64 `json:"monthlySavings,omitempty"`
+}
+
+func NewReport(prices map[string]float64) *Report {
+	p := make(map[string]float64, len(defaultPrices)+len(prices))
+	for k, v := range defaultPrices {
+		p[k] = v
+	}
+	for k, v := range prices {
+		p[k] = v
+	}
+
+	return &Report{
+		Created: time.Now().UTC(),
+		prices:  p,
+		index:   make(map[string]int),
+	}
+}
+
+// countOnly keeps the totals of r without holding on to every item, for
+// streamed runs nobody wants a report of, so memory stays bounded.
+func (r *Report) countOnly() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counting = true
+	r.open = make(map[string]Resource)
+}
+
+// count folds res into the totals, or keeps it open while it is planned.
+func (r *Report) count(k string, res Resource) {
+	delete(r.open, k)
+	if res.Status == statusPlanned {
+		r.open[k] = res
+		return
+	}
+	r.counted.add(res)
+}
+
+// context records the items a reaper skips while listing.
+func (r *Report) context(ctx context.Context, account, section, region string) context.Context {
+	return aws.WithSkipped(ctx, func(item, reason string) {
+		r.set(Resource{Account: account, Section: section, Region: region, Item: item, Status: statusSkipped, Reason: reason})
+	})
+}
+
+// planned records the candidates of a reaper along with their size, or what
+// they were before a reaper that changes items changes them.
+func (r *Report) planned(account, section, region string, reaper aws.Reaper, items []string) {
+	sizer, _ := reaper.(aws.Sizer)
+	changer, _ := reaper.(aws.Changer)
+
+	for _, item := range items {
+		res := Resource{
+			Account:     account,
+			Section:     section,
+			Region:      region,
+			Item:        item,
+			Description: reaper.Describe(item),
+			Status:      statusPlanned,
+		}
+
+		if changer != nil {
+			res.Previous = changer.Previous(item)
+		}
+
+		if sizer != nil {
+			res.Bytes = sizer.Size(item)
+			res.MonthlySavings = float64(res.Bytes) / gib * r.prices[section]
+		}
+
+		r.set(res)
+	}
+
+	r.journal.record(statusPlanned, account, section, region, items...)
+}
+
+// deleting records items about to be deleted in the journal. The report
+// only records how their deletion ended.
+func (r *Report) deleting(account, section, region string, items []string) {
+	r.journal.record(statusDeleting, account, section, region, items...)
+}
+
+// deleted marks planned items deleted, or failed with the reason err gives.
+func (r *Report) deleted(account, section, region string, items []string, err error) {
+	var f aws.Failures
+	partial := errors.As(err, &f)
+
+	for _, item := range items {
+		status, reason := statusDeleted, ""
+		if partial {
+			if e, ok := f[item]; ok {
+				status, reason = statusFailed, e.Error()
+			}
+		} else if err != nil {
+			status, reason = statusFailed, err.Error()
+		}
+
+		r.update(account, section, region, item, status, reason)
+	}
+}
+
+// failed records results that failed before their items were known.
+func (r *Report) failed(results []result) {
+	for _, res := range results {
+		if res.err == nil || res.count > 0 {
+			continue
+		}
+
+		r.set(Resource{Account: res.account, Section: res.section, Region: res.region, Status: statusFailed, Reason: res.err.Error()})
+	}
+}
+
+func (r *Report) set(res Resource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key(res.Account, res.Section, res.Region, res.Item)
+	if r.counting {
+		r.count(k, res)
+		return
+	}
+
+	if i, ok := r.index[k]; ok {
+		r.Resources[i] = res
+		return
+	}
+
+	r.index[k] = len(r.Resources)
+	r.Resources = append(r.Resources, res)
+}
+
+func (r *Report) update(account, section, region, item, status, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := key(account, section, region, item)
+	if r.counting {
+		res, ok := r.open[k]
+		if !ok {
+			res = Resource{Account: account, Section: section, Region: region, Item: item}
+		}
+		res.Status, res.Reason = status, reason
+		r.count(k, res)
+		r.journal.record(status, account, section, region, item)
+		return
+	}
+
+	i, ok := r.index[k]
+	if !ok {
+		r.index[k] = len(r.Resources)
+		r.Resources = append(r.Resources, Resource{Account: account, Section: section, Region: region, Item: item})
+		i = r.index[k]
+	}
+
+	r.Resources[i].Status = status
+	r.Resources[i].Reason = reason
+
+	r.journal.record(status, account, section, region, item)
+}
+
+func key(account, section, region, item string) string {
+	return strings.Join([]string{account, section, region, item}, "\x00")
+}
+
+func (r *Report) total() {
+	t := r.counted
+	for _, res := range r.open {
+		t.add(res)
+	}
+	for _, res := range r.Resources {
+		t.add(res)
+	}
+	r.Totals = t
+}
+
+func (t *Totals) add(res Resource) {
+	if res.Item != "" {
+		t.Considered++
+	}
+
+	switch res.Status {
+	case statusPlanned:
+		t.Planned++
+		t.BytesPlanned += res.Bytes
+		t.PlannedSavings += res.MonthlySavings
+	case statusDeleted:
+		t.Deleted++
+		t.BytesReclaimed += res.Bytes
+		t.MonthlySavings += res.MonthlySavings
+	case statusSkipped:
+		t.Skipped++
+	case statusFailed:
+		t.Failed++
+	}
+}
+
+func (r *Report) totals() Totals {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.total()
+	return r.Totals
+}
+
+// Write writes the report to every path, formatted by its extension.
+func (r *Report) Write(paths []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.total()
+	r.Throttles = aws.Throttles()
+
+	var errs []error
+	for _, path := range paths {
+		var b []byte
+		var err error
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			b, err = json.MarshalIndent(r, "", "  ")
+		case ".md", ".markdown":
+			b = r.markdown()
+		case ".csv":
+			b, err = r.csv()
+		default:
+			err = fmt.Errorf("unsupported report format %q, use .json, .md, or .csv", filepath.Ext(path))
+		}
+
+		if err == nil {
+			err = os.WriteFile(path, b, 0o644)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("report %s: %w", path, err))
+			continue
+		}
+
+		logger.Logger.Info().Str("report", path).Msg("wrote tidy report")
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Report) markdown() []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# tidy report\n\ncreated %s\n\n", r.Created.Format(time.RFC3339))
+	fmt.Fprintf(&b, "| considered | planned | deleted | skipped | failed | bytes planned | bytes reclaimed | planned savings | monthly savings |\n")
+	fmt.Fprintf(&b, "| ---: | ---: | ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %d | %d | $%.2f | $%.2f |\n\n",
+		r.Totals.Considered, r.Totals.Planned, r.Totals.Deleted, r.Totals.Skipped, r.Totals.Failed,
+		r.Totals.BytesPlanned, r.Totals.BytesReclaimed, r.Totals.PlannedSavings, r.Totals.MonthlySavings)
+
+	if len(r.Throttles) > 0 {
+		fmt.Fprintf(&b, "| service | throttled requests |\n")
+		fmt.Fprintf(&b, "| --- | ---: |\n")
+		for _, service := range slices.Sorted(maps.Keys(r.Throttles)) {
+			fmt.Fprintf(&b, "| %s | %d |\n", service, r.Throttles[service])
+		}
+		fmt.Fprintf(&b, "\n")
+	}
+
+	fmt.Fprintf(&b, "| account | section | region | item | status | reason | bytes | monthly savings |\n")
+	fmt.Fprintf(&b, "| --- | --- | --- | --- | --- | --- | ---: | ---: |\n")
+	for _, res := range r.Resources {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %d | $%.2f |\n",
+			cell(res.Account), cell(res.Section), cell(res.Region), cell(res.Item),
+			res.Status, cell(res.Reason), res.Bytes, res.MonthlySavings)
+	}
+
+	changed := slices.DeleteFunc(slices.Clone(r.Resources), func(res Resource) bool { return res.Previous == "" })
+	if len(changed) > 0 {
+		fmt.Fprintf(&b, "\n| account | section | region | item | previous | status |\n")
+		fmt.Fprintf(&b, "| --- | --- | --- | --- | --- | --- |\n")
+		for _, res := range changed {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n",
+				cell(res.Account), cell(res.Section), cell(res.Region), cell(res.Item), cell(res.Previous), res.Status)
+		}
+	}
+
+	return []byte(b.String())
+}
+
+func cell(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+}
+
+func (r *Report) csv() ([]byte, error) {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+
+	rows := [][]string{{"account", "section", "region", "item", "description", "status", "reason", "previous", "bytes", "monthlySavings"}}
+	for _, res := range r.Resources {
+		rows = append(rows, []string{
+			res.Account, res.Section, res.Region, res.Item, res.Description, res.Status, res.Reason, res.Previous,
+			strconv.FormatInt(res.Bytes, 10), strconv.FormatFloat(res.MonthlySavings, 'f', 4, 64),
+		})
+	}
+
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+
+	return []byte(b.String()), nil
+}
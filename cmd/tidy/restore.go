@@ -0,0 +1,112 @@
+package tidy
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/stxkxs/ok-cli/aws"
+	"github.com/stxkxs/ok-cli/logger"
+	"os"
+	"strings"
+	"time"
+)
+
+var restoreName string
+var restoreBucket string
+var restoreParameters []string
+var restoreTimeout time.Duration
+
+var restore = &cobra.Command{
+	Use:   "restore <snapshot>",
+	Short: "restore a deleted cloudformation stack",
+	Long:  `recreates a cloudformation stack from the snapshot tidy saved before deleting it, in the account and region it was taken in`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Logger.Debug().
+			Strs("args", args).
+			Msg("ok tidy restore")
+
+		snap, err := aws.ReadStackSnapshot(args[0])
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Str("snapshot", args[0]).
+				Msg("error reading stack snapshot")
+			os.Exit(exitFailure)
+		}
+
+		c := LoadTidyConf()
+		if c == nil {
+			os.Exit(exitFailure)
+		}
+
+		r, err := c.restore(snap)
+		if err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Msg("error preparing stack restore")
+			os.Exit(exitFailure)
+		}
+
+		if err := aws.RestoreStack(interruptible(), snap, r); err != nil {
+			logger.Logger.Error().
+				Err(err).
+				Str("snapshot", args[0]).
+				Str("stack", snap.Stack).
+				Msg("error restoring stack")
+			os.Exit(exitFailure)
+		}
+	},
+}
+
+// restore resolves the restore flags, and the role of the snapshot account
+// when it is one of the configured accounts.
+func (c *Tidy) restore(snap *aws.StackSnapshot) (aws.Restore, error) {
+	r := aws.Restore{
+		Name:       restoreName,
+		Bucket:     restoreBucket,
+		Parameters: make(map[string]string),
+		Timeout:    restoreTimeout,
+	}
+
+	for _, p := range restoreParameters {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok {
+			return r, fmt.Errorf("invalid parameter %q, expected key=value", p)
+		}
+		r.Parameters[key] = value
+	}
+
+	for _, a := range c.Accounts {
+		if a.Id != "" && a.Id != snap.Account {
+			continue
+		}
+
+		// accounts configured by role alone are matched by the account the
+		// role belongs to.
+		credentials, id, err := a.Credentials()
+		if err != nil && a.Id == "" {
+			logger.Logger.Warn().
+				Err(err).
+				Str("role", a.Role).
+				Msg("unable to assume account role. continuing.")
+			continue
+		}
+		if err != nil {
+			return r, err
+		}
+
+		if id == snap.Account {
+			r.Credentials = credentials
+			break
+		}
+	}
+
+	return r, nil
+}
+
+func init() {
+	restore.Flags().StringVar(&restoreName, "name", "", "restore under another stack name")
+	restore.Flags().StringVar(&restoreBucket, "bucket", "", "stage the template in this s3 bucket, required for templates over 51200 bytes")
+	restore.Flags().StringArrayVar(&restoreParameters, "parameter", nil, "override a stack parameter as key=value, required for NoEcho parameters")
+	restore.Flags().DurationVar(&restoreTimeout, "timeout", time.Hour, "how long to wait for the stack to be created")
+}
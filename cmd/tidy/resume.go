@@ -0,0 +1,161 @@
+package tidy
+
+import (
+	"context"
+	"errors"
+	"github.com/stxkxs/ok-cli/aws"
+	"github.com/stxkxs/ok-cli/logger"
+	"io/fs"
+	"slices"
+	"sync"
+)
+
+// resumed continues the run recorded in the journal, without confirmation
+// since the run was confirmed when it started.
+func (c *Tidy) resumed(rep *Report) []result {
+	j, entries, err := OpenJournal(environment)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Logger.Info().
+			Str("environment", environment).
+			Msg("no tidy journal to resume")
+		return nil
+	}
+	if err != nil {
+		logger.Logger.Error().
+			Err(err).
+			Msg("error opening tidy journal")
+		return []result{{err: err}}
+	}
+
+	rep.journal = j
+
+	todo, deleting, finished, streamed := unfinished(entries)
+	streamed = streamed && !finished
+	if !streamed && todo.count() == 0 && deleting.count() == 0 {
+		logger.Logger.Info().
+			Str("journal", j.path).
+			Msg("previous tidy run left nothing to resume")
+		return nil
+	}
+
+	logger.Logger.Info().
+		Str("journal", j.path).
+		Bool("finished", finished).
+		Bool("streamed", streamed).
+		Int("planned", todo.count()).
+		Int("deleting", deleting.count()).
+		Msg("resuming tidy run")
+
+	return c.resume(interruptible(), todo, deleting, streamed, rep)
+}
+
+// resume deletes what an earlier run left undone. Items it left deleting
+// are first reconciled against their live state so deletions still in
+// progress are waited for rather than started again. Every section is then
+// listed and filtered again: journaled items that are gone count as deleted,
+// items the filters now spare, e.g. since they were protected, are kept,
+// items no longer selected are dropped from the plan, and the rest is deleted along with, for streamed runs, the candidates the
+// run had not reached.
+func (c *Tidy) resume(ctx context.Context, todo, deleting *Plan, streamed bool, rep *Report) []result {
+	regions := func(t target, s section) ([]string, error) {
+		return union(todo.Accounts[t.account][s.name], deleting.Accounts[t.account][s.name]), nil
+	}
+	if streamed {
+		regions = resolved
+	}
+
+	return c.each(regions, func(t target, s section, region string) (int, error) {
+		r := s.reaper(region)
+		items := todo.Accounts[t.account][s.name][region]
+		inflight := deleting.Accounts[t.account][s.name][region]
+
+		reconciled, remaining, err := aws.Reconcile(ctx, r, inflight)
+		if err != nil {
+			return len(items) + len(inflight), err
+		}
+		rep.deleted(t.account, s.name, region, reconciled, nil)
+
+		journaled := append(slices.Clone(items), remaining...)
+		if !streamed && len(journaled) == 0 {
+			return len(reconciled), nil
+		}
+
+		// a resumed plan only deletes what was confirmed, so it marks nothing.
+		recheck := ctx
+		if !streamed {
+			recheck = aws.WithPending(ctx, func(string) {})
+		}
+
+		gone, candidates, err := s.recheck(recheck, rep, t.account, r, region, journaled)
+		if err != nil {
+			return len(journaled) + len(reconciled), err
+		}
+		rep.deleted(t.account, s.name, region, gone, nil)
+
+		if !streamed {
+			planned := set(journaled)
+			candidates = slices.DeleteFunc(candidates, func(item string) bool {
+				return !planned[item]
+			})
+		}
+
+		s.planned(rep, t.account, r, region, candidates)
+		if len(candidates) == 0 {
+			return len(reconciled) + len(gone), nil
+		}
+
+		return len(candidates) + len(reconciled) + len(gone), s.delete(ctx, rep, t.account, r, region, candidates)
+	})
+}
+
+// recheck lists and filters the items of r again. It returns the journaled
+// items that no longer exist, and every candidate. Journaled items the
+// filters drop are recorded as skipped with the reason, and so are those no
+// longer listed yet still in aws, e.g. since the configuration changed.
+func (s section) recheck(ctx context.Context, rep *Report, account string, r aws.Reaper, region string, journaled []string) (gone, candidates []string, err error) {
+	var mu sync.Mutex
+	spared := make(map[string]bool)
+
+	ctx = aws.WithSkipped(ctx, func(item, reason string) {
+		mu.Lock()
+		spared[item] = true
+		mu.Unlock()
+		rep.update(account, s.name, region, item, statusSkipped, reason)
+	})
+
+	listed, err := r.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	candidates, err = r.Filter(ctx, listed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	live := make(map[string]bool, len(listed))
+	for _, item := range listed {
+		live[item] = true
+	}
+
+	var unlisted []string
+	for _, item := range journaled {
+		if !live[item] && !spared[item] {
+			unlisted = append(unlisted, item)
+		}
+	}
+
+	gone, err = aws.Gone(ctx, r, unlisted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deleted := set(gone)
+	for _, item := range unlisted {
+		if !deleted[item] {
+			rep.update(account, s.name, region, item, statusSkipped, "dropped from the plan, no longer selected")
+		}
+	}
+
+	return gone, candidates, nil
+}
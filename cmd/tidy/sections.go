@@ -0,0 +1,383 @@
+package tidy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	awssdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stxkxs/ok-cli/aws"
+	"github.com/stxkxs/ok-cli/logger"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	defaultParallelism = 4
+	defaultAccount     = "default"
+)
+
+const (
+	exitFailure = 1
+	exitPartial = 2
+)
+
+// target is an account tidy runs against along with the credentials used to
+// reach it. err is set when the credentials could not be resolved.
+type target struct {
+	account     string
+	credentials awssdk.CredentialsProvider
+	err         error
+}
+
+type section struct {
+	name        string
+	region      string
+	regions     []string
+	credentials awssdk.CredentialsProvider
+	config      aws.ReaperConfig
+}
+
+type result struct {
+	account string
+	section string
+	region  string
+	count   int
+	err     error
+}
+
+// targets resolves every configured account by assuming its role, or the
+// account behind the default credentials when no accounts are configured.
+func (c *Tidy) targets() []target {
+	if len(c.Accounts) == 0 {
+		id, err := aws.AccountId(nil)
+		if err != nil {
+			logger.Logger.Warn().
+				Err(err).
+				Msg("unable to resolve default account. continuing.")
+			id = defaultAccount
+		}
+		return []target{{account: id}}
+	}
+
+	var targets []target
+	for _, a := range c.Accounts {
+		credentials, id, err := a.Credentials()
+		targets = append(targets, target{account: cmp.Or(id, a.Id, a.Role), credentials: credentials, err: err})
+	}
+
+	return targets
+}
+
+// sections returns the configured sections in registration order.
+func (c *Tidy) sections(t target) []section {
+	var sections []section
+	for _, r := range reapers {
+		config, ok := c.Sections[r.name]
+		if !ok {
+			continue
+		}
+
+		region, regions := config.Scope()
+		sections = append(sections, section{
+			name:        r.name,
+			region:      region,
+			regions:     regions,
+			credentials: t.credentials,
+			config:      config,
+		})
+	}
+
+	return sections
+}
+
+func (s section) reaper(region string) aws.Reaper {
+	return s.config.Reaper(region, s.credentials)
+}
+
+// list lists the candidates of r and records them, and everything r
+// skipped, in the report.
+func (s section) list(ctx context.Context, rep *Report, account string, r aws.Reaper, region string) ([]string, error) {
+	items, err := aws.Candidates(rep.context(ctx, account, s.name, region), r)
+	if err != nil {
+		return nil, err
+	}
+
+	s.planned(rep, account, r, region, items)
+
+	return items, nil
+}
+
+// stream deletes the candidates of r page by page while it is still listing
+// and records every page in the report. It returns how many items it planned.
+func (s section) stream(ctx context.Context, rep *Report, account string, r aws.Reaper, region string) (int, error) {
+	return aws.Stream(rep.context(ctx, account, s.name, region), s.name, region, r, aws.Hooks{
+		Planned: func(items []string) {
+			s.planned(rep, account, r, region, items)
+		},
+		Deleting: func(items []string) {
+			rep.deleting(account, s.name, region, items)
+		},
+		Deleted: func(items []string, err error) {
+			rep.deleted(account, s.name, region, items, err)
+		},
+	})
+}
+
+func (s section) planned(rep *Report, account string, r aws.Reaper, region string, items []string) {
+	for _, item := range items {
+		logger.Logger.Debug().Str("region", region).Str("item", r.Describe(item)).Msg("tidy candidate")
+	}
+
+	rep.planned(account, s.name, region, r, items)
+}
+
+// mark marks items with r for deletion once the grace period passed, by
+// filtering them again outside of planning. Marked items are recorded as
+// skipped in the report.
+func (s section) mark(ctx context.Context, rep *Report, account string, r aws.Reaper, region string, items []string) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	_, err := r.Filter(rep.context(ctx, account, s.name, region), items)
+	return err
+}
+
+// delete deletes items with r and records the outcome in the report.
+func (s section) delete(ctx context.Context, rep *Report, account string, r aws.Reaper, region string, items []string) error {
+	rep.deleting(account, s.name, region, items)
+	err := r.Delete(ctx, items)
+	rep.deleted(account, s.name, region, items, err)
+	return err
+}
+
+// interrupted is set once a run was interrupted, so its journal is left for
+// --resume.
+var interrupted atomic.Bool
+
+// interruptible returns a context cancelled by the first ctrl-c or sigterm,
+// so deletions stop starting new batches while the report still gets
+// written. A second ctrl-c exits right away.
+func interruptible() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		interrupted.Store(true)
+
+		logger.Logger.Warn().
+			Msg("interrupted. finishing deletions in flight, interrupt again to exit now.")
+		cancel()
+	}()
+
+	return ctx
+}
+
+func (s section) resolve() ([]string, error) {
+	return aws.Regions(s.region, s.regions, s.credentials)
+}
+
+// each runs fn for every account, section, and region. Accounts run one
+// after another and regions fan out in parallel. Failures are recorded in
+// the results and never stop the remaining sections or accounts.
+func (c *Tidy) each(regions func(t target, s section) ([]string, error), fn func(t target, s section, region string) (int, error)) []result {
+	var results []result
+
+	for _, t := range c.targets() {
+		if t.err != nil {
+			logger.Logger.Error().
+				Err(t.err).
+				Str("account", t.account).
+				Msg("error resolving tidy account")
+			results = append(results, result{account: t.account, err: t.err})
+			continue
+		}
+
+		for _, s := range c.sections(t) {
+			r, err := regions(t, s)
+			if err != nil {
+				logger.Logger.Error().
+					Err(err).
+					Str("account", t.account).
+					Str("section", s.name).
+					Msg("error resolving tidy regions")
+				results = append(results, result{account: t.account, section: s.name, err: err})
+				continue
+			}
+
+			rs := fanOut(c.Parallelism, r, func(region string) (int, error) {
+				return fn(t, s, region)
+			})
+
+			for i := range rs {
+				rs[i].account = t.account
+				rs[i].section = s.name
+			}
+			results = append(results, rs...)
+
+			if failed(rs) {
+				logger.Logger.Error().
+					Str("account", t.account).
+					Str("section", s.name).
+					Msg("error destroying tidy section. continuing.")
+			}
+		}
+	}
+
+	return results
+}
+
+func resolved(_ target, s section) ([]string, error) {
+	return s.resolve()
+}
+
+// fanOut runs fn once per region with at most parallelism regions in
+// flight and returns the results in region order.
+func fanOut(parallelism int, regions []string, fn func(region string) (int, error)) []result {
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+
+	results := make([]result, len(regions))
+	sem := make(chan struct{}, parallelism)
+
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			count, err := fn(region)
+			results[i] = result{region: region, count: count, err: err}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// errs joins the errors of every failed result.
+func errs(results []result) error {
+	var joined []error
+	for _, r := range results {
+		if r.err != nil {
+			joined = append(joined, fmt.Errorf("%s %s %s: %w", r.account, r.section, r.region, r.err))
+		}
+	}
+	return errors.Join(joined...)
+}
+
+func failed(results []result) bool {
+	return slices.ContainsFunc(results, func(r result) bool { return r.err != nil })
+}
+
+// failures flattens the errors of every failed result into one line per
+// failed item, or per region when the region failed as a whole.
+func failures(results []result) []string {
+	var lines []string
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+
+		var f aws.Failures
+		if !errors.As(r.err, &f) {
+			lines = append(lines, fmt.Sprintf("%s %s %s: %v", r.account, r.section, r.region, r.err))
+			continue
+		}
+
+		for _, item := range slices.Sorted(maps.Keys(f)) {
+			lines = append(lines, fmt.Sprintf("%s %s %s %s: %v", r.account, r.section, r.region, item, f[item]))
+		}
+	}
+	return lines
+}
+
+// exitCode is 0 when every result succeeded, exitPartial when at least some
+// work was done, and exitFailure when nothing could be done at all.
+func exitCode(results []result) int {
+	if !failed(results) {
+		return 0
+	}
+
+	for _, r := range results {
+		if r.err == nil {
+			return exitPartial
+		}
+
+		var f aws.Failures
+		if errors.As(r.err, &f) && len(f) < r.count {
+			return exitPartial
+		}
+	}
+
+	return exitFailure
+}
+
+// exit logs the aggregated failures and exits with the code for results.
+func exit(msg string, results []result) {
+	code := exitCode(results)
+	if code == 0 {
+		return
+	}
+
+	logger.Logger.Error().
+		Int("code", code).
+		Strs("failures", failures(results)).
+		Msg(msg)
+	os.Exit(code)
+}
+
+// summarize logs one line per account and region with the item count of
+// every section and any errors encountered.
+func summarize(msg string, results []result) {
+	type key struct{ account, region string }
+
+	var keys []key
+	grouped := make(map[key][]result)
+
+	for _, r := range results {
+		k := key{r.account, r.region}
+		if _, ok := grouped[k]; !ok {
+			keys = append(keys, k)
+		}
+		grouped[k] = append(grouped[k], r)
+	}
+
+	for _, k := range keys {
+		e := logger.Logger.Info().Str("account", k.account).Str("region", k.region)
+		for _, r := range grouped[k] {
+			if r.section == "" {
+				e = e.AnErr("error", r.err)
+				continue
+			}
+
+			e = e.Int(r.section, r.count)
+			if r.err != nil {
+				e = e.AnErr(r.section+".error", r.err)
+			}
+		}
+		e.Msg(msg)
+	}
+
+	if throttles := aws.Throttles(); len(throttles) > 0 {
+		e := logger.Logger.Warn()
+		for service, n := range throttles {
+			e = e.Int64(service, n)
+		}
+		e.Msg("aws throttled requests by service")
+	}
+}
@@ -0,0 +1,160 @@
+package terminal
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const prototype = "prototype"
+
+// Prompt asks questions on a terminal. Questions go to stderr so they never
+// mix with the json logs on stdout.
+type Prompt struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func NewPrompt() *Prompt {
+	return &Prompt{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+}
+
+// Interactive reports whether stdin and stdout are both attached to a
+// terminal, so a user is present to answer prompts.
+func Interactive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stdout.Fd())
+}
+
+// Token returns what a user has to type to confirm a destructive command:
+// yes in the prototype environment, identity (an account id or environment
+// name) anywhere else.
+func Token(environment, identity string) string {
+	if environment == "" || strings.EqualFold(environment, prototype) {
+		return "yes"
+	}
+	return identity
+}
+
+// Select lists items numbered from 1 and lets the user deselect some of them
+// by number or range, e.g. 2 4-6. It returns the indexes of the kept items,
+// and an empty answer keeps every item.
+func (p *Prompt) Select(title string, items []string) ([]int, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	fmt.Fprintf(p.out, "%s\n", title)
+	for i, item := range items {
+		fmt.Fprintf(p.out, "  %4d  %s\n", i+1, item)
+	}
+
+	for {
+		fmt.Fprintf(p.out, "deselect (numbers or ranges, enter to keep all): ")
+
+		answer, err := p.in.ReadString('\n')
+		if err != nil && answer == "" {
+			return nil, err
+		}
+
+		deselected, err := parseSelection(answer, len(items))
+		if err != nil {
+			fmt.Fprintf(p.out, "%v\n", err)
+			continue
+		}
+
+		var kept []int
+		for i := range items {
+			if !deselected[i+1] {
+				kept = append(kept, i)
+			}
+		}
+
+		return kept, nil
+	}
+}
+
+// Choose lets the user deselect items and then confirm the rest with token.
+// It returns the kept items.
+func (p *Prompt) Choose(title, token string, items []string) ([]string, error) {
+	kept, err := p.Select(title, items)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := make([]string, 0, len(kept))
+	for _, i := range kept {
+		chosen = append(chosen, items[i])
+	}
+
+	if len(chosen) == 0 {
+		return nil, nil
+	}
+
+	if err := p.Confirm(fmt.Sprintf("destroy %d of %d?", len(chosen), len(items)), token); err != nil {
+		return nil, err
+	}
+
+	return chosen, nil
+}
+
+// Destroy shows the items about to be destroyed, lets the user deselect some,
+// and requires the environment name outside of prototype. It returns every
+// item without asking when yes is set or no user is present.
+func Destroy(title, environment string, yes bool, items []string) ([]string, error) {
+	if yes || !Interactive() || len(items) == 0 {
+		return items, nil
+	}
+
+	return NewPrompt().Choose(title, Token(environment, environment), items)
+}
+
+// Confirm requires the user to type token and fails otherwise.
+func (p *Prompt) Confirm(question, token string) error {
+	fmt.Fprintf(p.out, "%s\ntype %q to continue: ", question, token)
+
+	answer, err := p.in.ReadString('\n')
+	if err != nil && answer == "" {
+		return err
+	}
+
+	if strings.TrimSpace(answer) != token {
+		return fmt.Errorf("confirmation %q did not match %q", strings.TrimSpace(answer), token)
+	}
+
+	return nil
+}
+
+func parseSelection(answer string, n int) (map[int]bool, error) {
+	selected := make(map[int]bool)
+
+	for _, field := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' }) {
+		from, to, isRange := strings.Cut(field, "-")
+		if !isRange {
+			to = from
+		}
+
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", field)
+		}
+
+		end, err := strconv.Atoi(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", field)
+		}
+
+		if start < 1 || end > n || start > end {
+			return nil, fmt.Errorf("selection %q is outside 1-%d", field, n)
+		}
+
+		for i := start; i <= end; i++ {
+			selected[i] = true
+		}
+	}
+
+	return selected, nil
+}